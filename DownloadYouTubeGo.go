@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,18 +20,36 @@ import (
 )
 
 type settings struct {
-	Email             string
-	MediaFolder       string
-	MediaFolderNotify string
-	RSSFolder         string
-	RSSTemplate       string
-	HTTPHost          string
-	Config            string
-	PlaylistItems     string
-	PushoverUserToken string
-	PodcastDownload   []YouTubeDownload `xml:"PodcastDownload"`
-	PodcastsNotifty   []PodcastsNotifty `xml:"PodcastsNotifty"`
-	RSSDownload       []RSSDownload     `xml:"RSSDownload"`
+	XMLName             xml.Name
+	Email               string
+	MediaFolder         string
+	MediaFolderNotify   string
+	RSSFolder           string
+	RSSTemplate         string
+	HTTPHost            string
+	Config              string
+	PlaylistItems       string
+	PushoverUserToken   string
+	RSSPath             string
+	ItemTemplate        string
+	ChannelRefreshHours string
+	FailOnLint          string
+	RunLock             string
+	HTTPListen          string
+	APIKey              string
+	WebSub              string
+	WebSubHub           string
+	WebSubLeaseSeconds  string
+	SendFeed            *YouTubeDownload `xml:"SendFeed"`
+	MetricsTextfile     string
+	Notifiers           []NotifierConfig `xml:"Notifier"`
+	SMTP                *SMTPConfig      `xml:"SMTP"`
+	EmailDigest         string
+	NotifyBatch         string
+	NotifyBatchScope    string
+	PodcastDownload     []YouTubeDownload `xml:"PodcastDownload"`
+	PodcastsNotifty     []PodcastsNotifty `xml:"PodcastsNotifty"`
+	RSSDownload         []RSSDownload     `xml:"RSSDownload"`
 }
 
 // type Tiktokfeed struct {
@@ -94,30 +113,56 @@ type YouTubeDownload struct {
 	FileFormat       string `xml:"FileFormat"`
 	DownloadArchive  string `xml:"DownloadArchive"`
 	FileQuality      string `xml:"FileQuality"`
-	ChannelThumbnail string `xml:"ChannelThumbnail"`
+	ChannelThumbnail string `xml:"ChannelThumbnail,omitempty"`
 	YouTubeURL       string `xml:"YouTubeURL"`
-	PushoverAppToken string `xml:"PushoverAppToken"`
+	PushoverAppToken string `xml:"PushoverAppToken,omitempty"`
 	// PushoverAppToken
+	FeedOptions
 }
 
 // RSSDownload Name="jimmyrees (TikTok)" ChannelID="TikTok" TikTokUsername="jimmyrees" FileFormat="mp4" DownloadArchive="/config/youtube-dl-archive-TikTok-ALL.txt" FileQuality="best" ChannelThumbnail="https://www.tiktok.com/favicon.ico" TikTokFeed="http://10.0.0.186:3008/?action=display&amp;bridge=TikTokBridge&amp;format=Atom&amp;context=By+user&amp;username=%40" />
 type RSSDownload struct {
 	Name             string `xml:"Name"`
 	ChannelID        string `xml:"ChannelID"`
-	TikTokUsername   string `xml:"TikTokUsername"`
+	TikTokUsername   string `xml:"TikTokUsername,omitempty"`
 	FileFormat       string `xml:"FileFormat"`
 	DownloadArchive  string `xml:"DownloadArchive"`
 	FileQuality      string `xml:"FileQuality"`
-	ChannelThumbnail string `xml:"ChannelThumbnail"`
-	YouTubeURL       string `xml:"YouTubeURL"`
+	ChannelThumbnail string `xml:"ChannelThumbnail,omitempty"`
+	YouTubeURL       string `xml:"YouTubeURL,omitempty"`
 	TikTokFeed       string `xml:"TikTokFeed"`
-	PushoverAppToken string `xml:"PushoverAppToken"`
+	PushoverAppToken string `xml:"PushoverAppToken,omitempty"`
+	FeedOptions
+}
+
+// FeedOptions are the per-feed settings shared by PodcastDownload and RSSDownload.
+type FeedOptions struct {
+	MaxItems             string           `xml:"MaxItems,omitempty"`
+	PageSize             string           `xml:"PageSize,omitempty"`
+	Atom                 string           `xml:"Atom,omitempty"`
+	JSONFeed             string           `xml:"JSONFeed,omitempty"`
+	Group                string           `xml:"Group,omitempty"`
+	RSSTemplate          string           `xml:"RSSTemplate,omitempty"`
+	ItemTemplate         string           `xml:"ItemTemplate,omitempty"`
+	ChannelRefreshHours  string           `xml:"ChannelRefreshHours,omitempty"`
+	Subtitles            string           `xml:"Subtitles,omitempty"`
+	Private              string           `xml:"Private,omitempty"`
+	SquareArtwork        string           `xml:"SquareArtwork,omitempty"`
+	ArtworkSize          string           `xml:"ArtworkSize,omitempty"`
+	ArtworkTitle         string           `xml:"ArtworkTitle,omitempty"`
+	DeleteAfterFetchDays string           `xml:"DeleteAfterFetchDays,omitempty"`
+	Notifiers            []NotifierConfig `xml:"Notifier,omitempty"`
+	NotifyTemplates
+	NotifyBatch string `xml:"NotifyBatch,omitempty"`
 }
 
 type PodcastsNotifty struct {
-	Name             string `xml:"Name"`
-	YouTubeURL       string `xml:"YouTubeURL"`
-	PushoverAppToken string `xml:"PushoverAppToken"`
+	Name             string           `xml:"Name"`
+	YouTubeURL       string           `xml:"YouTubeURL"`
+	PushoverAppToken string           `xml:"PushoverAppToken,omitempty"`
+	Notifiers        []NotifierConfig `xml:"Notifier,omitempty"`
+	NotifyTemplates
+	NotifyBatch string `xml:"NotifyBatch,omitempty"`
 }
 
 type JsonData struct {
@@ -130,24 +175,45 @@ type JsonData struct {
 	channel_url     string
 	duration_string string
 	filesize_approx float64
+	upload_date     string
+	timestamp       float64
 }
 
-type JsonChannelData struct {
-	thumbnail   string
-	description string
+const settingsPath = "/config/settings.xml"
+
+var settingsXML settings
+
+// uploadTime is when the video was published, falling back to now when
+// yt-dlp didn't report it.
+func uploadTime(jsonpayload JsonData) time.Time {
+	if jsonpayload.timestamp > 0 {
+		return time.Unix(int64(jsonpayload.timestamp), 0)
+	}
+	if t, err := time.ParseInLocation("20060102", jsonpayload.upload_date, time.Local); err == nil {
+		return t
+	}
+	return time.Now()
 }
 
 func isOlderThan(t time.Time) bool {
 	return time.Now().Sub(t) > 168*time.Hour
 }
 
-func DeleteOldFiles(dir string) {
+// DeleteOldFiles removes the expired episodes of a feed. A feed whose media
+// folder does not exist yet, because its first run failed, has nothing to
+// remove.
+func DeleteOldFiles(dir string, pOptions FeedOptions) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	pChannelID := filepath.Base(filepath.Clean(dir))
+	feedState := GetFeedState(pChannelID)
+	var deletedIDs []string
+
 	descfiles, descerr := WalkMatch(dir, "*.description")
 
 	if descerr != nil {
-		log.Printf("------------------      START List description Files ERROR")
-		log.Fatal(descerr)
-		log.Printf("------------------      END List description Files ERROR")
+		return errors.New("Error listing description files: " + descerr.Error())
 	}
 
 	for _, fname := range descfiles {
@@ -160,20 +226,49 @@ func DeleteOldFiles(dir string) {
 		fname_file, fname_fileerr := os.Stat(fname)
 
 		if fname_fileerr != nil {
-			log.Printf("------------------      START List fname_fileerr ERROR")
-			log.Fatal(fname_fileerr)
-			log.Printf("------------------      END List fname_fileerr ERROR")
+			return errors.New("Error reading " + fname + ": " + fname_fileerr.Error())
 		}
 
-		if isOlderThan(fname_file.ModTime()) {
+		if EpisodeExpired(feedState, filepath.Base(fname_noext), fname_file.ModTime(), pOptions) {
+			deletedIDs = append(deletedIDs, filepath.Base(fname_noext))
+			MetricAdd("dyg_retention_deleted_episodes_total", 1, "feed", pChannelID)
 			log.Printf("DELETE FILE: " + fname_noext + ".description")
 			os.Remove(fname_noext + ".description")
 			log.Printf("DELETE FILE: " + fname_noext + ".mp4")
 			os.Remove(fname_noext + ".mp4")
 			log.Printf("DELETE FILE: " + fname_noext + ".info.json")
 			os.Remove(fname_noext + ".info.json")
+			for _, transcriptFile := range TranscriptFiles(fname_noext) {
+				log.Println("DELETE FILE: " + transcriptFile)
+				os.Remove(transcriptFile)
+			}
+			for _, artworkFile := range ArtworkFiles(fname_noext) {
+				log.Println("DELETE FILE: " + artworkFile)
+				os.Remove(artworkFile)
+			}
+		}
+	}
+
+	// ~~~~~ Remove Fetched Episodes from RSS ~~~~
+	if _, afterFetch := fetchRetention(pOptions); afterFetch && len(deletedIDs) > 0 {
+		if err := RemoveEpisodes(settingsXML.RSSFolder, pChannelID, deletedIDs, pOptions); err != nil {
+			log.Println("Remove Episodes Error: " + err.Error())
 		}
+		UpdateFeedState(pChannelID, func(feed *FeedState) {
+			for _, id := range deletedIDs {
+				delete(feed.Fetched, id)
+			}
+		})
 	}
+	return nil
+}
+
+func fileSize(fp string) string {
+	info, err := os.Stat(fp)
+	if err != nil {
+		return "0"
+	}
+	return strconv.FormatInt(info.Size(), 10)
 }
 
 func IsValid(fp string) bool {
@@ -211,7 +306,7 @@ func IsValidURL(fp string) bool {
 	resp, err := http.Get(fp)
 	if err != nil {
 		// print(err.Error())
-		log.Printf("IsValidURL Status: " + resp.Status)
+		log.Println("IsValidURL Error: " + err.Error())
 		// log.Printf("IsValidURL Error: " + err.Error())
 		return false
 	} else {
@@ -284,65 +379,70 @@ func DownloadFile(filepath string, url string) error {
 	return err
 }
 
-func NotifyPushover(Config string, AppToken string, UserToken string, nTitle string, nBody string, pThumbnail string, nURL string) {
-	// NotifyPushover("apb75jkyb1iegxzp4styr5tgidq3fg","RSS Podcast Downloaded (" + pName + ")","<html><body>" + ytvideo_title + "<br /><br />--------------------------------------------<br /><br />" + ytvideo_description + "</body></html>",ytvideo_thumbnail)
-
-	log.Println("-----		")
-	log.Println("-----		START NotifyPushover")
-	log.Println("-----		")
+// DownloadChannelJSON writes the channel's info.json with yt-dlp. With
+// refresh set the old one is replaced.
+func DownloadChannelJSON(sMediaFolder string, pChannelID string, pFileFormat string, pFileQuality string, pYouTubeURL string, refresh bool) error {
+	// =========================================================
+	// ============= Download Channel JSON Only ================
+	// =========================================================
 
-	// ~~~~~~~~~~~~~~ Print Data ~~~~~~~~~~~~~~~~
+	channel_filename_json := sMediaFolder + pChannelID + "/" + pChannelID + ".info.json"
+	dlname := pChannelID + "/" + pChannelID + ".%(ext)s"
 
-	log.Printf("------------------      START Print Data")
-	log.Printf("Config: " + Config)
-	log.Printf("AppToken: " + AppToken)
-	log.Printf("UserToken: " + UserToken)
-	log.Printf("nTitle: " + nTitle)
-	log.Printf("nBody: " + nBody)
-	log.Printf("pThumbnail: " + pThumbnail)
-	log.Printf("nURL: " + nURL)
-	log.Printf("------------------      END Print Data")
-
-	// ~~~~~~~~~~ Download Thumbnail ~~~~~~~~~~~~
-
-	savename := ""
-	if strings.HasSuffix(pThumbnail, ".jpg") {
-		savename = "maxresdefault.jpg"
+	if refresh {
+		// --no-overwrites would keep the old channel JSON
+		log.Println("DELETE FILE: " + channel_filename_json)
+		os.Remove(channel_filename_json)
 	}
 
-	if strings.HasSuffix(pThumbnail, ".webp") {
-		savename = "maxresdefault.webp"
-	}
+	log.Println("-----		")
+	log.Println("-----		Start Download Channel JSON Only")
+	log.Println("-----		")
 
-	if strings.HasSuffix(pThumbnail, ".jpg") == false && strings.HasSuffix(pThumbnail, ".webp") == false {
-		savename = "maxresdefault.jpg"
-	}
+	// out, err := exec.Command("yt-dlp", "-v", "-o", fmt.Sprintf("%s/%s", sMediaFolder, dlname), "--playlist-items", "0", "--write-info-json", "--restrict-filenames", "--add-metadata", "--merge-output-format", pFileFormat, "--format", pFileQuality, "--abort-on-error", "--abort-on-unavailable-fragment", "--no-overwrites", "--continue", pYouTubeURL).Output()
 
-	err := DownloadFile(Config+savename, pThumbnail)
+	out := exec.Command("yt-dlp", "-v", "-o", sMediaFolder+dlname, "--playlist-items", "0", "--write-info-json", "--restrict-filenames", "--add-metadata", "--merge-output-format", pFileFormat, "--format", pFileQuality, "--abort-on-error", "--abort-on-unavailable-fragment", "--no-overwrites", "--continue", pYouTubeURL)
+	out.Stdout = os.Stdout
+	out.Stderr = os.Stderr
+
+	ytdlpStarted := time.Now()
+	err := out.Run()
+	ObserveYTDLP(pChannelID, "channel", ytdlpStarted)
 	if err != nil {
-		// panic(err)
-		log.Printf("------------------      START DownloadFile ERROR")
-		log.Fatal(err.Error())
-		log.Printf("------------------      END DownloadFile ERROR")
-	}
-	fmt.Println("Downloaded: " + pThumbnail)
+		log.Printf("------------------      START YT-DLP Channel JSON Only ERROR")
+		log.Println(err.Error())
+		log.Printf("------------------      END YT-DLP Channel JSON Only ERROR")
+		return errors.New("yt-dlp channel JSON: " + err.Error())
 
-	// ~~~~~~~~~~~~~~ HTTP Post ~~~~~~~~~~~~~~~~~
+	}
+	return nil
+}
 
-	out := exec.Command("curl", "-s", "--form-string", "token="+AppToken, "--form-string", "user="+UserToken, "--form-string", "title="+nTitle, "--form-string", "message="+nBody, "--form-string", "html=1", "-F", "attachment=@"+Config+savename, "https://api.pushover.net/1/messages.json")
-	out.Stdout = os.Stdout
-	out.Stderr = os.Stderr
+// RefreshChannelFromJSON rebuilds the channel header of an existing feed from
+// the channel's info.json.
+func RefreshChannelFromJSON(sMediaFolder string, sRSSFolder string, RSSTemplate string, pName string, pChannelID string, pChannelThumbnail string, pYouTubeURL string, pOptions FeedOptions) error {
+	log.Println("-----		")
+	log.Println("-----		Refresh Channel Header")
+	log.Println("-----		")
 
-	if err := out.Run(); err != nil {
-		log.Printf("------------------      START NotifyPushover ERROR")
-		log.Fatal(err.Error())
-		log.Printf("------------------      END NotifyPushover ERROR")
+	channel_filename_json := sMediaFolder + pChannelID + "/" + pChannelID + ".info.json"
+	channelInfo, channelErr := ReadInfoJSON(channel_filename_json)
+	if channelErr != nil {
+		return errors.New("Error reading JSON File " + channel_filename_json + ": " + channelErr.Error())
+	}
+	channelLink := fmt.Sprint(channelInfo["channel_url"])
+	if channelInfo["channel_url"] == nil {
+		channelLink = fmt.Sprint(channelInfo["webpage_url"])
 	}
 
-	log.Println("-----		END NotifyPushover")
+	channelData, channelErr := GetChannelData(sMediaFolder, pName, pChannelID, pChannelThumbnail, pYouTubeURL, channelLink, pOptions)
+	if channelErr != nil {
+		return channelErr
+	}
+	return RefreshChannelHeader(sRSSFolder, ChannelTemplatePath(RSSTemplate, pOptions), channelData, pChannelThumbnail, pOptions)
 }
 
-func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPHost string, Config string, pName string, pChannelID string, pFileFormat string, pDownloadArchive string, pFileQuality string, pChannelThumbnail string, PlaylistItems string, pYouTubeURL string, pPushoverAppToken string, pPushoverUserToken string) {
+func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPHost string, Config string, pName string, pChannelID string, pFileFormat string, pDownloadArchive string, pFileQuality string, pChannelThumbnail string, PlaylistItems string, pYouTubeURL string, pPushoverAppToken string, pPushoverUserToken string, pOptions FeedOptions, pVideoURL string, notifyBatch *NotificationBatch) error {
 	log.Println("-----		")
 	log.Println("-----		Start Run_YTDLP")
 	log.Println("-----		")
@@ -365,32 +465,32 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 	log.Printf("pYouTubeURL: " + pYouTubeURL)
 	log.Printf("pPushoverAppToken: " + pPushoverAppToken)
 	log.Printf("pPushoverUserToken: " + pPushoverUserToken)
+	log.Println("pOptions.MaxItems: " + pOptions.MaxItems)
+	log.Println("pOptions.PageSize: " + pOptions.PageSize)
+	log.Println("pOptions.Atom: " + pOptions.Atom)
+	log.Println("pOptions.JSONFeed: " + pOptions.JSONFeed)
+	log.Println("pOptions.RSSTemplate: " + pOptions.RSSTemplate)
+	log.Println("pOptions.ItemTemplate: " + pOptions.ItemTemplate)
+	log.Println("pOptions.ChannelRefreshHours: " + pOptions.ChannelRefreshHours)
+	log.Println("pOptions.Subtitles: " + pOptions.Subtitles)
+	log.Println("pOptions.SquareArtwork: " + pOptions.SquareArtwork)
+	log.Println("pOptions.ArtworkSize: " + pOptions.ArtworkSize)
+	log.Println("pOptions.ArtworkTitle: " + pOptions.ArtworkTitle)
+	log.Println("pOptions.DeleteAfterFetchDays: " + pOptions.DeleteAfterFetchDays)
+	log.Println("pOptions.NotifyTitle: " + pOptions.NotifyTitle)
+	log.Println("pOptions.NotifyBody: " + pOptions.NotifyBody)
+	log.Println("pOptions.NotifyBatch: " + pOptions.NotifyBatch)
 	log.Println("-----		")
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
-	if pChannelID != "TikTok" {
-		// =========================================================
-		// ============= Download Channel JSON Only ================
-		// =========================================================
-
-		dlname := pChannelID + "/" + pChannelID + ".%(ext)s"
-
-		log.Println("-----		")
-		log.Println("-----		Start Download Channel JSON Only")
-		log.Println("-----		")
-
-		// out, err := exec.Command("yt-dlp", "-v", "-o", fmt.Sprintf("%s/%s", sMediaFolder, dlname), "--playlist-items", "0", "--write-info-json", "--restrict-filenames", "--add-metadata", "--merge-output-format", pFileFormat, "--format", pFileQuality, "--abort-on-error", "--abort-on-unavailable-fragment", "--no-overwrites", "--continue", pYouTubeURL).Output()
-
-		out := exec.Command("yt-dlp", "-v", "-o", sMediaFolder+dlname, "--playlist-items", "0", "--write-info-json", "--restrict-filenames", "--add-metadata", "--merge-output-format", pFileFormat, "--format", pFileQuality, "--abort-on-error", "--abort-on-unavailable-fragment", "--no-overwrites", "--continue", pYouTubeURL)
-		out.Stdout = os.Stdout
-		out.Stderr = os.Stderr
-
-		if err := out.Run(); err != nil {
-			log.Printf("------------------      START YT-DLP Channel JSON Only ERROR")
-			log.Fatal(err.Error())
-			log.Printf("------------------      END YT-DLP Channel JSON Only ERROR")
+	channel_filename_json := sMediaFolder + pChannelID + "/" + pChannelID + ".info.json"
+	channelRefresh := pChannelID != "TikTok" && IsValid(sRSSFolder+RSSFileName(pChannelID)) && ChannelRefreshDue(pChannelID, pName, pChannelThumbnail, pOptions)
 
+	// the send-to-feed feed has no channel, its channel JSON is written by SendToFeed
+	if pChannelID != "TikTok" && pYouTubeURL != "" {
+		if err := DownloadChannelJSON(sMediaFolder, pChannelID, pFileFormat, pFileQuality, pYouTubeURL, channelRefresh); err != nil {
+			return err
 		}
 	}
 	// =========================================================
@@ -403,17 +503,47 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 	log.Println("-----		Download Videos with yt-dlp")
 	log.Println("-----		")
 
-	out2 := exec.Command("yt-dlp", "-v", "-o", sMediaFolder+dlname2, "--playlist-items", PlaylistItems, "--write-info-json", "--no-write-playlist-metafiles", "--download-archive", pDownloadArchive, "--restrict-filenames", "--add-metadata", "--merge-output-format", pFileFormat, "--format", pFileQuality, "--abort-on-error", "--abort-on-unavailable-fragment", "--no-overwrites", "--continue", "--write-description", pYouTubeURL)
+	ytdlpArgs := []string{"-v", "-o", sMediaFolder + dlname2, "--playlist-items", PlaylistItems, "--write-info-json", "--no-write-playlist-metafiles", "--download-archive", pDownloadArchive, "--restrict-filenames", "--add-metadata", "--merge-output-format", pFileFormat, "--format", pFileQuality, "--abort-on-error", "--abort-on-unavailable-fragment", "--no-overwrites", "--continue", "--write-description"}
+	ytdlpArgs = append(ytdlpArgs, SubtitleArgs(pOptions)...)
+	// a single pushed or sent video instead of the whole channel
+	if pVideoURL != "" {
+		ytdlpArgs = append(ytdlpArgs, pVideoURL)
+	} else {
+		ytdlpArgs = append(ytdlpArgs, pYouTubeURL)
+	}
+
+	out2 := exec.Command("yt-dlp", ytdlpArgs...)
 	out2.Stdout = os.Stdout
 	out2.Stderr = os.Stderr
 
-	if err := out2.Run(); err != nil {
+	ytdlpStarted := time.Now()
+	err := out2.Run()
+	ObserveYTDLP(pChannelID, "videos", ytdlpStarted)
+	if err != nil {
 		log.Printf("------------------      START YT-DLP ERROR")
-		log.Fatal(err.Error())
+		log.Println(err.Error())
 		log.Printf("------------------      END YT-DLP ERROR")
+		return errors.New("yt-dlp: " + err.Error())
 
 	}
 
+	// =========================================================
+	// ================ Refresh Channel Header =================
+	// =========================================================
+
+	// a feed failing lint fails the run only once every item is handled, so
+	// no episode is left in the feed without its notification
+	var lintErr error
+
+	if channelRefresh && IsValid(channel_filename_json) {
+		if refreshErr := RefreshChannelFromJSON(sMediaFolder, sRSSFolder, RSSTemplate, pName, pChannelID, pChannelThumbnail, pYouTubeURL, pOptions); refreshErr != nil {
+			if !IsFeedLintError(refreshErr) {
+				return refreshErr
+			}
+			lintErr = refreshErr
+		}
+	}
+
 	// =========================================================
 	// ================ List Downloaded Files ==================
 	// =========================================================
@@ -427,8 +557,9 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 	if descerr != nil {
 		log.Printf("------------------      START List Downloaded Files ERROR")
 		// log.Printf("%s", descerr)
-		log.Fatal(descerr)
+		log.Println(descerr.Error())
 		log.Printf("------------------      END List Downloaded Files ERROR")
+		return descerr
 	}
 
 	log.Println("-----		")
@@ -475,7 +606,7 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 			// Let's first read the `config.json` file
 			content, contenterr := ioutil.ReadFile(fname_json)
 			if contenterr != nil {
-				log.Fatal("Error when opening file: ", contenterr)
+				return errors.New("Error when opening file: " + contenterr.Error())
 			}
 
 			// defining a map
@@ -485,7 +616,7 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 			if maperr != nil {
 				// print out if error is not nil
 				// fmt.Println(maperr)
-				log.Fatal("Error reading JSON File ", maperr)
+				return errors.New("Error reading JSON File " + fname_json + ": " + maperr.Error())
 			}
 
 			var jsonpayload JsonData
@@ -507,23 +638,18 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 			jsonpayload.channel_url = fmt.Sprint(mapresult["channel_url"])
 			jsonpayload.webpage_url = fmt.Sprint(mapresult["webpage_url"])
 			jsonpayload.duration_string = fmt.Sprint(mapresult["duration_string"])
+			jsonpayload.upload_date = fmt.Sprint(mapresult["upload_date"])
+			if timestamp, ok := mapresult["timestamp"].(float64); ok {
+				jsonpayload.timestamp = timestamp
+			}
 			// jsonpayload.filesize_approx = mapresult["filesize_approx"].(float64)
 			// var Filesize float64
 			// Filesize = (float64(jsonpayload.filesize_approx) / 1024) / 1024
 			// jsonpayload.filesize_approx = roundFloat(Filesize, 2)
 
-			// -- Test Thumbnail Path ----
-			ytvideo_thumbnail := "https://i.ytimg.com/vi_webp/" + jsonpayload.id + "/maxresdefault.webp"
-			ValidURI := IsValidURL(ytvideo_thumbnail)
-			if ValidURI == true {
-				jsonpayload.thumbnail = ytvideo_thumbnail
-			}
-
-			ytvideo_thumbnail2 := "https://i.ytimg.com/vi_webp/" + jsonpayload.id + "/maxresdefault.jpg"
-			ValidURI2 := IsValidURL(ytvideo_thumbnail2)
-			if ValidURI2 == true {
-				jsonpayload.thumbnail = ytvideo_thumbnail2
-			}
+			// -- Thumbnail Candidates ---
+			// probed and cached only when the item is added below
+			thumbnailCandidates := ThumbnailCandidates(jsonpayload.id, jsonpayload.webpage_url, jsonpayload.thumbnail)
 
 			// --- Print Final Data ------
 
@@ -545,6 +671,7 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 			log.Println("-----		")
 			rssPathFile := sRSSFolder + pChannelID + "RSS.xml"
 			log.Printf("rssPathFile: " + rssPathFile)
+			feedLock := LockFeed(pChannelID)
 			rssPathFile_Valid := IsValid(rssPathFile)
 			if rssPathFile_Valid == false {
 				log.Println("-----		")
@@ -554,70 +681,12 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 				// =============== Get Channel Information =================
 				// =========================================================
 
-				channel_filename_json := sMediaFolder + pChannelID + "/" + pChannelID + ".info.json"
-				content2, contenterr2 := ioutil.ReadFile(channel_filename_json)
-				if contenterr2 != nil {
-					log.Fatal("Error when opening file: ", contenterr2)
-				}
-
-				// defining a map
-				var mapresult2 map[string]interface{}
-				maperr2 := json.Unmarshal([]byte(content2), &mapresult2)
-
-				if maperr2 != nil {
-					log.Fatal("Error reading JSON File ", maperr2)
-				}
-
-				var jsonchannelpayload JsonChannelData
-				jsonchannelpayload.description = ""
-				jsonchannelpayload.thumbnail = ""
-
-				// ~~~~~~~~~~~ Get Description ~~~~~~~~~~~~~~
-				jsonchannelpayload.description = fmt.Sprint(mapresult2["description"])
-
-				if pChannelThumbnail == "" {
-					// ~~~~~~~~ Get Channel Thumbnail ~~~~~~~~~~~
-					a, _ := json.Marshal(mapresult2["thumbnails"])
-					channelthumbjson := string(a)
-					var arrresultthumb []map[string]interface{}
-					maperrthumb := json.Unmarshal([]byte(channelthumbjson), &arrresultthumb)
-					if maperrthumb != nil {
-						// print out if error is not nil
-						// fmt.Println(maperr)
-						log.Fatal("Error reading JSON File ", maperrthumb)
-					}
-
-					for i := len(arrresultthumb) - 1; i >= 0; i-- {
-						// log.Printf(fmt.Sprintf(arrresultthumb[i]["id"].(string)))
-						thumbid := fmt.Sprintf(arrresultthumb[i]["id"].(string))
-						thumburl := fmt.Sprintf(arrresultthumb[i]["url"].(string))
-
-						if thumbid == "avatar_uncropped" {
-							jsonchannelpayload.thumbnail = thumburl
-							break
-						}
-					}
-
-					// -- Test Thumbnail Path ----
-					ValidChannelURI := IsValidURL(jsonchannelpayload.thumbnail)
-					if ValidChannelURI == false {
-						jsonchannelpayload.thumbnail = ""
-					} else {
-						pChannelThumbnail = jsonchannelpayload.thumbnail
-					}
-				} else {
-					// -- Test Thumbnail Path ----
-					ValidChannelURI := IsValidURL(pChannelThumbnail)
-					if ValidChannelURI == false {
-						jsonchannelpayload.thumbnail = ""
-					}
+				channelData, channelErr := GetChannelData(sMediaFolder, pName, pChannelID, pChannelThumbnail, pYouTubeURL, jsonpayload.channel_url, pOptions)
+				if channelErr != nil {
+					Unlock(feedLock)
+					return channelErr
 				}
 
-				// log.Printf("jsonchannelpayload.description: " + jsonchannelpayload.description)
-				log.Printf("pChannelThumbnail: " + pChannelThumbnail)
-
-				// ~~~~~~ End Get Channel Thumbnail ~~~~~~~~~
-
 				// =========================================================
 				// =================== Create RSS Feed =====================
 				// =========================================================
@@ -627,25 +696,21 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 				log.Println("-----		")
 
 				// ~~~~~~~~ Read RSS Template File ~~~~~~~~~~
-				log.Println("-----		Read RSS Template File")
-				rssTemplateContent, rssTemplateErr := ioutil.ReadFile(RSSTemplate) // the file is inside the local directory
+				log.Println("-----		Read RSS Template File: " + ChannelTemplatePath(RSSTemplate, pOptions))
+				rssTemplateData, rssTemplateErr := RenderChannelTemplate(ChannelTemplatePath(RSSTemplate, pOptions), channelData)
 				if rssTemplateErr != nil {
-					log.Fatal(rssTemplateErr)
+					Unlock(feedLock)
+					return rssTemplateErr
 				}
 
-				// ----- Replace Data --------
-				rssTemplateData := string(rssTemplateContent)
-				rssTemplateData = strings.ReplaceAll(rssTemplateData, "[CHANNEL_LINK]", jsonpayload.channel_url)
-				rssTemplateData = strings.ReplaceAll(rssTemplateData, "[PODCAST_TITLE]", pName)
-				rssTemplateData = strings.ReplaceAll(rssTemplateData, "[PODCAST_IMAGE]", pChannelThumbnail)
-				rssTemplateData = strings.ReplaceAll(rssTemplateData, "[PODCAST_DESCRIPTION]", jsonchannelpayload.description)
-
 				fmt.Println("rssTemplateData:", rssTemplateData)
 
 				// -- Write New RSS File -----
-				if writersserr := os.WriteFile(rssPathFile, []byte(rssTemplateData), 0666); writersserr != nil {
-					log.Fatal(writersserr)
+				if writersserr := WriteFileAtomic(rssPathFile, []byte(rssTemplateData), 0666); writersserr != nil {
+					Unlock(feedLock)
+					return writersserr
 				}
+				MarkChannelRefreshed(pChannelID, pName, pChannelThumbnail)
 			}
 
 			// =========================================================
@@ -655,49 +720,103 @@ func Run_YTDLP(sMediaFolder string, sRSSFolder string, RSSTemplate string, HTTPH
 			log.Println("-----		Create Item XML for RSS File")
 			log.Println("-----		")
 
-			log.Println("-----		Read RSS Template File")
-			rssContent, rssErr := ioutil.ReadFile(rssPathFile) // the file is inside the local directory
+			log.Println("-----		Read RSS Feed and Archive Pages")
+			rssFeed, rssErr := LoadFeedSet(sRSSFolder, pChannelID)
 			if rssErr != nil {
-				log.Fatal(rssErr)
+				Unlock(feedLock)
+				return rssErr
 			}
-			RSSData := string(rssContent)
 
-			if strings.Contains(RSSData, jsonpayload.id) {
+			episodeGUID := EpisodeGUID(jsonpayload.webpage_url, pChannelID, jsonpayload.id)
+			if rssFeed.HasEpisode(episodeGUID, jsonpayload.id) {
 				log.Printf("Item (" + jsonpayload.id + ") already in RSS file")
 			} else {
 				// ------ Get PubDate --------
 				log.Printf("Item (" + jsonpayload.id + ") not in RSS file")
-				PubDateNow := time.Now()
-				PubDate := PubDateNow.Format("02/01/2006 03:04:05 -0700")
+				PubDate := uploadTime(jsonpayload).Format(time.RFC1123Z)
 				log.Printf("PubDate: " + PubDate)
 
-				// ~~~~~~~~~ Replace & in string ~~~~~~~~~~~~
-				jsonpayload_thumbnail_amp := strings.ReplaceAll(jsonpayload.thumbnail, "&", "&amp;")
-
 				// ~~~~~ Replace invalid tiktok data ~~~~~~~~
-				jsonpayload.channel_url = pYouTubeURL
+				if pYouTubeURL != "" {
+					jsonpayload.channel_url = pYouTubeURL
+				}
+
+				// ----- Cache Thumbnail -----
+				episodeArtwork := EpisodeArtwork(pChannelID, pName, jsonpayload.id, thumbnailCandidates, pOptions)
+				jsonpayload.thumbnail = episodeArtwork.Source
+				log.Println("episodeArtwork: " + episodeArtwork.URL)
 
 				// ----- RSS Item Data -------
-				RSSItemsData := "\t\t<item>\n\t\t\t<title><![CDATA[" + jsonpayload.title + "]]></title>\n\t\t\t<description><![CDATA[" + jsonpayload.description + "]]></description>\n\t\t\t<link>" + jsonpayload.webpage_url + "</link>\n\t\t\t<guid isPermaLink=\"false\">" + jsonpayload.webpage_url + "</guid>\n\t\t\t<pubDate>" + PubDate + "</pubDate>\n\t\t\t<podcast:chapters url=\"[ITEM_CHAPTER_URL]\" type=\"application/json\"/>\n\t\t\t<itunes:subtitle><![CDATA[" + jsonpayload.uploader_url + "]]></itunes:subtitle>\n\t\t\t<itunes:summary><![CDATA[" + jsonpayload.uploader_url + "]]></itunes:summary>\n\t\t\t<itunes:author><![CDATA[" + jsonpayload.uploader_url + "]]></itunes:author>\n\t\t\t<author><![CDATA[" + jsonpayload.uploader_url + "]]></author>\n\t\t\t<itunes:image href=\"" + jsonpayload_thumbnail_amp + "\"/>\n\t\t\t<itunes:explicit>No</itunes:explicit>\n\t\t\t<itunes:keywords>youtube</itunes:keywords>\n\t\t\t<enclosure url=\"" + HTTPHost + "podcasts/" + pChannelID + "/" + jsonpayload.id + ".mp4" + "\" type=\"video/mpeg\" length=\"" + jsonpayload.duration_string + "\"/>\n\t\t\t<podcast:person href=\"" + jsonpayload.channel_url + "\" img=\"" + jsonpayload_thumbnail_amp + "\">" + jsonpayload.uploader_url + "</podcast:person>\n\t\t\t<podcast:images srcset=\"" + jsonpayload_thumbnail_amp + " 2000w\"/>\n\t\t\t<itunes:duration>" + jsonpayload.duration_string + "</itunes:duration>\n\t\t</item>\n<!-- INSERT_ITEMS_HERE -->"
-				RSSData = strings.ReplaceAll(RSSData, "<!-- INSERT_ITEMS_HERE -->", RSSItemsData)
+				feedToken := FeedToken(pChannelID, pOptions)
+				transcripts := PrepareTranscripts(fname_noext, HTTPHost+"podcasts/"+pChannelID+"/")
+				for t := range transcripts {
+					transcripts[t].URL = WithToken(transcripts[t].URL, feedToken)
+				}
+				episode := EpisodeData{
+					ID:              jsonpayload.id,
+					GUID:            episodeGUID,
+					Title:           jsonpayload.title,
+					Description:     jsonpayload.description,
+					Link:            jsonpayload.webpage_url,
+					Thumbnail:       episodeArtwork.URL,
+					ThumbnailWidth:  episodeArtwork.Width,
+					Uploader:        fmt.Sprint(mapresult["uploader"]),
+					UploaderURL:     jsonpayload.uploader_url,
+					ChannelURL:      jsonpayload.channel_url,
+					Duration:        jsonpayload.duration_string,
+					DurationSeconds: durationSeconds(jsonpayload.duration_string),
+					Published:       uploadTime(jsonpayload),
+					PubDate:         PubDate,
+					EnclosureURL:    WithToken(HTTPHost+"podcasts/"+pChannelID+"/"+jsonpayload.id+".mp4", feedToken),
+					EnclosureType:   EnclosureType(fname_mp4),
+					EnclosureLength: fileSize(fname_mp4),
+					Channel: ChannelData{
+						Name:       pName,
+						ChannelID:  pChannelID,
+						YouTubeURL: pYouTubeURL,
+						HTTPHost:   HTTPHost,
+						FeedURL:    WithToken(FeedURL(RSSFileName(pChannelID)), feedToken),
+					},
+					Transcripts: transcripts,
+					Info:        mapresult,
+				}
+				if channelInfo, err := ReadInfoJSON(channel_filename_json); err == nil {
+					episode.Channel.Info = channelInfo
+					episode.Channel.Description = fmt.Sprint(channelInfo["description"])
+				}
+
+				RSSItemsData, itemErr := RenderItemTemplate(ItemTemplatePath(pOptions), episode)
+				if itemErr != nil {
+					Unlock(feedLock)
+					return itemErr
+				}
+				rssFeed.Items = append(rssFeed.Items, NewFeedItem(RSSItemsData))
 
 				// -- Add Data to RSS File -----
-				if writersserr := os.WriteFile(rssPathFile, []byte(RSSData), 0666); writersserr != nil {
-					log.Fatal(writersserr)
+				if writersserr := WriteFeedSet(sRSSFolder, pChannelID, rssFeed, pOptions); writersserr != nil {
+					if !IsFeedLintError(writersserr) {
+						Unlock(feedLock)
+						return writersserr
+					}
+					lintErr = writersserr
 				}
 				log.Printf("Item added to RSS file: " + jsonpayload.id)
+				RecordEpisode(pChannelID, fname_mp4)
 
 				// =========================================================
 				// =================== Notify Pushover =====================
 				// =========================================================
 
-				NotifyPushover(Config, pPushoverAppToken, pPushoverUserToken, "RSS Podcast Downloaded ("+pName+")", "<html><body>"+jsonpayload.title+"<br /><br />--------------------------------------------<br /><br />"+jsonpayload.description+"</body></html>", jsonpayload.thumbnail, jsonpayload.webpage_url)
+				notification := RenderNotification(NewNotificationData("download", pName, jsonpayload, mapresult), pOptions.NotifyTemplates)
+				notifyBatch.Add(pChannelID, FeedNotifierConfigs(pOptions.Notifiers, pPushoverAppToken, pPushoverUserToken), notification)
 			}
+			Unlock(feedLock)
 		}
 	}
+	return lintErr
 }
 
-func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadArchive string, PlaylistItems string, pYouTubeURL string, pPushoverAppToken string, pPushoverUserToken string) {
+func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadArchive string, PlaylistItems string, pYouTubeURL string, pPushoverAppToken string, pPushoverUserToken string, pNotifiers []NotifierConfig, pTemplates NotifyTemplates, notifyBatch *NotificationBatch) error {
 
 	log.Println("-----		")
 	log.Println("-----		Start NotifyYouTube")
@@ -713,10 +832,14 @@ func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadAr
 	out2.Stdout = os.Stdout
 	out2.Stderr = os.Stderr
 
-	if err := out2.Run(); err != nil {
+	ytdlpStarted := time.Now()
+	err := out2.Run()
+	ObserveYTDLP(NotifyStateKey(pName), "notify", ytdlpStarted)
+	if err != nil {
 		log.Printf("------------------      START NotifyYouTube YT-DLP ERROR")
-		log.Fatal(err.Error())
+		log.Println(err.Error())
 		log.Printf("------------------      END NotifyYouTube YT-DLP ERROR")
+		return errors.New("yt-dlp: " + err.Error())
 
 	}
 
@@ -733,8 +856,9 @@ func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadAr
 	if descerr != nil {
 		log.Printf("------------------      START List Downloaded Files ERROR")
 		// log.Printf("%s", descerr)
-		log.Fatal(descerr)
+		log.Println(descerr.Error())
 		log.Printf("------------------      END List Downloaded Files ERROR")
+		return descerr
 	}
 
 	log.Println("-----		")
@@ -781,7 +905,7 @@ func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadAr
 			// Let's first read the `config.json` file
 			content, contenterr := ioutil.ReadFile(fname_json)
 			if contenterr != nil {
-				log.Fatal("Error when opening file: ", contenterr)
+				return errors.New("Error when opening file: " + contenterr.Error())
 			}
 
 			// defining a map
@@ -791,7 +915,7 @@ func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadAr
 			if maperr != nil {
 				// print out if error is not nil
 				// fmt.Println(maperr)
-				log.Fatal("Error reading JSON File ", maperr)
+				return errors.New("Error reading JSON File " + fname_json + ": " + maperr.Error())
 			}
 
 			var jsonpayload JsonData
@@ -813,22 +937,20 @@ func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadAr
 			jsonpayload.channel_url = fmt.Sprint(mapresult["channel_url"])
 			jsonpayload.webpage_url = fmt.Sprint(mapresult["webpage_url"])
 			jsonpayload.duration_string = fmt.Sprint(mapresult["duration_string"])
+			jsonpayload.upload_date = fmt.Sprint(mapresult["upload_date"])
+			if timestamp, ok := mapresult["timestamp"].(float64); ok {
+				jsonpayload.timestamp = timestamp
+			}
 			// jsonpayload.filesize_approx = mapresult["filesize_approx"].(float64)
 			// var Filesize float64
 			// Filesize = (float64(jsonpayload.filesize_approx) / 1024) / 1024
 			// jsonpayload.filesize_approx = roundFloat(Filesize, 2)
 
 			// -- Test Thumbnail Path ----
-			ytvideo_thumbnail := "https://i.ytimg.com/vi_webp/" + jsonpayload.id + "/maxresdefault.webp"
-			ValidURI := IsValidURL(ytvideo_thumbnail)
-			if ValidURI == true {
-				jsonpayload.thumbnail = ytvideo_thumbnail
-			}
-
-			ytvideo_thumbnail2 := "https://i.ytimg.com/vi_webp/" + jsonpayload.id + "/maxresdefault.jpg"
-			ValidURI2 := IsValidURL(ytvideo_thumbnail2)
-			if ValidURI2 == true {
-				jsonpayload.thumbnail = ytvideo_thumbnail2
+			// yt-dlp's own thumbnail needs no probing, the candidates are
+			// only checked when the info.json has none
+			if jsonpayload.thumbnail == "" || jsonpayload.thumbnail == "<nil>" {
+				jsonpayload.thumbnail = BestThumbnailURL(ThumbnailCandidates(jsonpayload.id, jsonpayload.webpage_url, ""))
 			}
 
 			// --- Print Final Data ------
@@ -849,24 +971,155 @@ func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadAr
 
 			// ~~~~~~~~~~~~ Add to Archive ~~~~~~~~~~~~~~
 
-			arch, archerr := os.OpenFile(pDownloadArchive, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 644)
-
-			if archerr != nil {
-				log.Printf(archerr.Error())
-			}
-			defer arch.Close()
-
-			if _, err := arch.WriteString("youtube " + jsonpayload.id + "\n"); err != nil {
-				log.Fatal(err)
+			if err := AppendLine(pDownloadArchive, "youtube "+jsonpayload.id); err != nil {
+				return err
 			}
 
 			// =========================================================
 			// =================== Notify Pushover =====================
 			// =========================================================
 
-			NotifyPushover(Config, pPushoverAppToken, pPushoverUserToken, "RSS YouTube Video Uploaded ("+pName+")", "<html><body>"+jsonpayload.title+"<br /><br />"+jsonpayload.webpage_url+"<br /><br />--------------------------------------------<br /><br />"+jsonpayload.description+"</body></html>", jsonpayload.thumbnail, jsonpayload.webpage_url)
+			notification := RenderNotification(NewNotificationData("upload", pName, jsonpayload, mapresult), pTemplates)
+			notifyBatch.Add(NotifyStateKey(pName), FeedNotifierConfigs(pNotifiers, pPushoverAppToken, pPushoverUserToken), notification)
+		}
+	}
+	return nil
+}
+
+// RunPodcastDownload runs one PodcastDownload entry and records the result.
+// pVideoURL limits the run to one video of the channel.
+func RunPodcastDownload(pPodcast YouTubeDownload, pVideoURL string) error {
+	runStarted := time.Now()
+	// notifications go out once the run is over, grouped with NotifyBatch
+	notifyBatch := NewNotificationBatch(pPodcast.ChannelID, pPodcast.Name, pPodcast.NotifyBatch)
+	defer notifyBatch.Send()
+	runErr := Run_YTDLP(settingsXML.MediaFolder, settingsXML.RSSFolder, settingsXML.RSSTemplate, settingsXML.HTTPHost, settingsXML.Config, pPodcast.Name, pPodcast.ChannelID, pPodcast.FileFormat, pPodcast.DownloadArchive, pPodcast.FileQuality, pPodcast.ChannelThumbnail, settingsXML.PlaylistItems, pPodcast.YouTubeURL, pPodcast.PushoverAppToken, settingsXML.PushoverUserToken, pPodcast.FeedOptions, pVideoURL, notifyBatch)
+	RecordRun(pPodcast.ChannelID, runStarted, runErr)
+	if err := DeleteOldFiles(settingsXML.MediaFolder+pPodcast.ChannelID+"/", pPodcast.FeedOptions); err != nil {
+		log.Println("Delete Old Files Error: " + err.Error())
+	}
+	return runErr
+}
+
+// MaintainPodcastDownload does what a run does for a PodcastDownload entry
+// besides polling for videos, for feeds whose videos arrive by WebSub push:
+// the channel header refresh and retention.
+func MaintainPodcastDownload(pPodcast YouTubeDownload) error {
+	var refreshErr error
+	if IsValid(settingsXML.RSSFolder+RSSFileName(pPodcast.ChannelID)) && ChannelRefreshDue(pPodcast.ChannelID, pPodcast.Name, pPodcast.ChannelThumbnail, pPodcast.FeedOptions) {
+		refreshErr = DownloadChannelJSON(settingsXML.MediaFolder, pPodcast.ChannelID, pPodcast.FileFormat, pPodcast.FileQuality, pPodcast.YouTubeURL, true)
+		if refreshErr == nil {
+			refreshErr = RefreshChannelFromJSON(settingsXML.MediaFolder, settingsXML.RSSFolder, settingsXML.RSSTemplate, pPodcast.Name, pPodcast.ChannelID, pPodcast.ChannelThumbnail, pPodcast.YouTubeURL, pPodcast.FeedOptions)
+		}
+	}
+	if err := DeleteOldFiles(settingsXML.MediaFolder+pPodcast.ChannelID+"/", pPodcast.FeedOptions); err != nil {
+		log.Println("Delete Old Files Error: " + err.Error())
+	}
+	return refreshErr
+}
+
+// RunPodcastsNotifty runs one PodcastsNotifty entry and records the result.
+// pVideoURL limits the run to one video of the channel.
+func RunPodcastsNotifty(pNotify PodcastsNotifty, pVideoURL string) error {
+	runStarted := time.Now()
+	notifyBatch := NewNotificationBatch(NotifyStateKey(pNotify.Name), pNotify.Name, pNotify.NotifyBatch)
+	defer notifyBatch.Send()
+	notifyURL := pNotify.YouTubeURL
+	if pVideoURL != "" {
+		notifyURL = pVideoURL
+	}
+	runErr := NotifyYouTube(settingsXML.MediaFolderNotify, settingsXML.Config, pNotify.Name, settingsXML.Config+"youtube-dl-notify.txt", settingsXML.PlaylistItems, notifyURL, pNotify.PushoverAppToken, settingsXML.PushoverUserToken, pNotify.Notifiers, pNotify.NotifyTemplates, notifyBatch)
+	RecordRun(NotifyStateKey(pNotify.Name), runStarted, runErr)
+	return runErr
+}
+
+// RunRSSDownload reads the TikTok feed of an RSSDownload entry and runs
+// Run_YTDLP for its latest items.
+func RunRSSDownload(pRSS RSSDownload) error {
+	runStarted := time.Now()
+	// one batch for all items of the run
+	notifyBatch := NewNotificationBatch(pRSS.ChannelID, pRSS.Name, pRSS.NotifyBatch)
+	defer notifyBatch.Send()
+
+	// ~~~~~~~~~ Read TikTok RSS Feed ~~~~~~~~~~~
+	err := DownloadFile(settingsXML.Config+"tiktok.json", pRSS.TikTokFeed+pRSS.TikTokUsername)
+	if err != nil {
+		RecordRun(pRSS.ChannelID, runStarted, err)
+		return err
+	}
+	log.Println("Downloaded: " + settingsXML.Config + "tiktok.json")
+
+	content, contenterr := ioutil.ReadFile(settingsXML.Config + "tiktok.json")
+	if contenterr != nil {
+		RecordRun(pRSS.ChannelID, runStarted, contenterr)
+		return contenterr
+	}
+
+	// defining a map
+	var mapresult map[string]interface{}
+	maperr := json.Unmarshal([]byte(content), &mapresult)
+
+	if maperr != nil {
+		// print out if error is not nil
+		// fmt.Println(maperr)
+		maperr = errors.New("Error reading JSON File " + settingsXML.Config + "tiktok.json: " + maperr.Error())
+		RecordRun(pRSS.ChannelID, runStarted, maperr)
+		return maperr
+	}
+
+	var jsonpayload TikTok
+
+	jsonpayload.Icon = fmt.Sprint(mapresult["icon"])
+	jsonpayload.Title = fmt.Sprint(mapresult["title"])
+	// jsonpayload. = fmt.Sprint(mapresult["items"])
+
+	log.Println("icon: " + jsonpayload.Icon)
+	log.Println("title: " + jsonpayload.Title)
+	log.Println("RSSFolder: " + settingsXML.RSSFolder)
+	log.Println("RSSTemplate: " + settingsXML.RSSTemplate)
+	log.Println("HTTPHost: " + settingsXML.HTTPHost)
+	log.Println("Config: " + settingsXML.Config)
+
+	// ~~~~~~~~~~ Loop through Items ~~~~~~~~~~~~
+
+	var jsonitemspayload Entry
+	jsonitemspayload.Link = ""
+	jsonitemspayload.Title = ""
+
+	a, _ := json.Marshal(mapresult["items"])
+	rssitemjson := string(a)
+	var arrresultitem []map[string]interface{}
+	maperrthumb := json.Unmarshal([]byte(rssitemjson), &arrresultitem)
+	if maperrthumb != nil {
+		// print out if error is not nil
+		// fmt.Println(maperr)
+		maperrthumb = errors.New("Error reading TikTok items: " + maperrthumb.Error())
+		RecordRun(pRSS.ChannelID, runStarted, maperrthumb)
+		return maperrthumb
+	}
+
+	var runErr error
+	for j := 0; j < 5 && j < len(arrresultitem); j++ {
+		// log.Printf(fmt.Sprintf(arrresultthumb[i]["id"].(string)))
+		// thumbid := fmt.Sprintf(arrresultitem[i]["id"].(string))
+		// thumburl := fmt.Sprintf(arrresultitem[i]["url"].(string))
+		jsonitemspayload.Title = fmt.Sprintf(arrresultitem[j]["title"].(string))
+		jsonitemspayload.Link = fmt.Sprintf(arrresultitem[j]["url"].(string))
+		log.Println("---  Item " + fmt.Sprint(j) + ": " + settingsXML.Config)
+		log.Println("jsonitemspayload.Title: " + jsonitemspayload.Title)
+		log.Println("jsonitemspayload.Link: " + jsonitemspayload.Link)
+
+		// Run_YTDLP(settingsXML.MediaFolder, settingsXML.Config, pRSS.Name, pRSS.DownloadArchive, settingsXML.PlaylistItems, jsonitemspayload.Link)
+
+		if itemErr := Run_YTDLP(settingsXML.MediaFolder, settingsXML.RSSFolder, settingsXML.RSSTemplate, settingsXML.HTTPHost, settingsXML.Config, pRSS.Name, pRSS.ChannelID, pRSS.FileFormat, pRSS.DownloadArchive, pRSS.FileQuality, pRSS.ChannelThumbnail, settingsXML.PlaylistItems, jsonitemspayload.Link, pRSS.PushoverAppToken, settingsXML.PushoverUserToken, pRSS.FeedOptions, "", notifyBatch); itemErr != nil && runErr == nil {
+			runErr = itemErr
+		}
+		if err := DeleteOldFiles(settingsXML.MediaFolder+pRSS.ChannelID+"/", pRSS.FeedOptions); err != nil {
+			log.Println("Delete Old Files Error: " + err.Error())
 		}
 	}
+	RecordRun(pRSS.ChannelID, runStarted, runErr)
+	return runErr
 }
 
 // func Run_RSS_YTDLP() {
@@ -876,7 +1129,7 @@ func NotifyYouTube(sMediaFolder string, Config string, pName string, pDownloadAr
 func main() {
 	// name := "Go Developers"
 	// log.Println("Hello World:", name)
-	xmlFile, err := os.Open("/config/settings.xml")
+	xmlFile, err := os.Open(settingsPath)
 	// xmlFile, err := os.Open("settingsLOCAL.xml")
 	if err != nil {
 		log.Println(err)
@@ -887,7 +1140,6 @@ func main() {
 	byteValue, _ := ioutil.ReadAll(xmlFile)
 
 	// we initialize our PodcastDownload array
-	var settingsXML settings
 	var validateXML Validate
 	// we unmarshal our byteArray which contains our
 	// xmlFiles content into 'users' which we defined above
@@ -901,6 +1153,32 @@ func main() {
 	log.Println("PushoverUserToken: " + settingsXML.PushoverUserToken)
 	log.Println("HTTPHost: " + settingsXML.HTTPHost)
 	log.Println("Config: " + settingsXML.Config)
+	log.Println("RSSPath: " + settingsXML.RSSPath)
+	log.Println("ItemTemplate: " + settingsXML.ItemTemplate)
+	log.Println("ChannelRefreshHours: " + settingsXML.ChannelRefreshHours)
+	log.Println("FailOnLint: " + settingsXML.FailOnLint)
+	log.Println("RunLock: " + settingsXML.RunLock)
+	log.Println("HTTPListen: " + settingsXML.HTTPListen)
+	log.Println("APIKey set: " + fmt.Sprint(settingsXML.APIKey != ""))
+	log.Println("WebSub: " + settingsXML.WebSub)
+	log.Println("WebSubHub: " + settingsXML.WebSubHub)
+	log.Println("WebSubLeaseSeconds: " + settingsXML.WebSubLeaseSeconds)
+	log.Println("SendFeed set: " + fmt.Sprint(settingsXML.SendFeed != nil))
+	log.Println("MetricsTextfile: " + settingsXML.MetricsTextfile)
+	log.Println("Notifiers: " + fmt.Sprint(len(settingsXML.Notifiers)))
+	log.Println("SMTP set: " + fmt.Sprint(settingsXML.SMTP != nil))
+	log.Println("EmailDigest: " + settingsXML.EmailDigest)
+	log.Println("NotifyBatch: " + settingsXML.NotifyBatch)
+	log.Println("NotifyBatchScope: " + settingsXML.NotifyBatchScope)
+
+	// =========================================================
+	// ====================== Run Command ======================
+	// =========================================================
+
+	if len(os.Args) > 1 {
+		RunCommand(os.Args[1], os.Args[2:])
+		return
+	}
 
 	// =========================================================
 	// ================== Validate Settings ====================
@@ -941,6 +1219,24 @@ func main() {
 	log.Println("HTTPHost Valid: " + fmt.Sprint(validateXML.HTTPHost))
 	log.Println("PlaylistItems Valid: " + fmt.Sprint(validateXML.PlaylistItems))
 
+	// =========================================================
+	// ======================= Run Lock ========================
+	// =========================================================
+
+	runLock, runLocked := LockRun()
+	if runLocked == false {
+		log.Println("Another run is still in progress, skipping this run")
+		return
+	}
+	defer Unlock(runLock)
+
+	// counters carry on from the last run's textfile
+	LoadRunMetrics()
+	defer SaveRunMetrics()
+
+	// with NotifyBatchScope run the feeds' notifications are sent together
+	StartRunBatch()
+
 	// =========================================================
 	// =========================================================
 	// =========================================================
@@ -949,7 +1245,7 @@ func main() {
 	// ######################## Loop PodcastDownload ##########################
 	// ########################################################################
 
-	if validateXML.MediaFolder == true && validateXML.RSSFolder == true && validateXML.RSSTemplate == true && validateXML.Config == true && validateXML.MediaFolderNotify == true && validateXML.PushoverUserToken == true && validateXML.HTTPHost == true && validateXML.PlaylistItems == true {
+	if validateXML.MediaFolder == true && validateXML.RSSFolder == true && validateXML.RSSTemplate == true && validateXML.Config == true && validateXML.MediaFolderNotify == true && validateXML.HTTPHost == true && validateXML.PlaylistItems == true {
 		log.Println("-----		")
 		log.Println("-----		Start Validate")
 		log.Println("-----		")
@@ -1025,8 +1321,14 @@ func main() {
 				log.Println("PlaylistItems: " + settingsXML.PlaylistItems)
 				log.Println("-----		")
 
-				Run_YTDLP(settingsXML.MediaFolder, settingsXML.RSSFolder, settingsXML.RSSTemplate, settingsXML.HTTPHost, settingsXML.Config, settingsXML.PodcastDownload[i].Name, settingsXML.PodcastDownload[i].ChannelID, settingsXML.PodcastDownload[i].FileFormat, settingsXML.PodcastDownload[i].DownloadArchive, settingsXML.PodcastDownload[i].FileQuality, settingsXML.PodcastDownload[i].ChannelThumbnail, settingsXML.PlaylistItems, settingsXML.PodcastDownload[i].YouTubeURL, settingsXML.PodcastDownload[i].PushoverAppToken, settingsXML.PushoverUserToken)
-				DeleteOldFiles(settingsXML.MediaFolder + settingsXML.PodcastDownload[i].ChannelID + "/")
+				if WebSubActive(settingsXML.PodcastDownload[i].ChannelID) {
+					log.Println("WebSub subscription active, not polling: " + settingsXML.PodcastDownload[i].Name)
+					if err := MaintainPodcastDownload(settingsXML.PodcastDownload[i]); err != nil {
+						log.Println("Channel Refresh Error: " + err.Error())
+					}
+				} else {
+					RunPodcastDownload(settingsXML.PodcastDownload[i], "")
+				}
 				log.Println("")
 			}
 		}
@@ -1039,7 +1341,7 @@ func main() {
 	// ######################## Loop PodcastsNotifty ##########################
 	// ########################################################################
 
-	if validateXML.MediaFolder == true && validateXML.RSSFolder == true && validateXML.RSSTemplate == true && validateXML.Config == true && validateXML.MediaFolderNotify == true && validateXML.PushoverUserToken == true && validateXML.HTTPHost == true && validateXML.PlaylistItems == true {
+	if validateXML.MediaFolder == true && validateXML.RSSFolder == true && validateXML.RSSTemplate == true && validateXML.Config == true && validateXML.MediaFolderNotify == true && validateXML.HTTPHost == true && validateXML.PlaylistItems == true {
 		log.Println("-----		")
 		log.Println("-----		Start PodcastsNotifty")
 		log.Println("-----		")
@@ -1081,10 +1383,17 @@ func main() {
 				log.Println("-----		")
 				log.Println("PodcastsNotifty.Name: " + settingsXML.PodcastsNotifty[i].Name)
 				log.Println("PodcastsNotifty.YouTubeURL: " + settingsXML.PodcastsNotifty[i].YouTubeURL)
+				log.Println("PodcastsNotifty.NotifyTitle: " + settingsXML.PodcastsNotifty[i].NotifyTitle)
+				log.Println("PodcastsNotifty.NotifyBody: " + settingsXML.PodcastsNotifty[i].NotifyBody)
+				log.Println("PodcastsNotifty.NotifyBatch: " + settingsXML.PodcastsNotifty[i].NotifyBatch)
 				log.Println("PlaylistItems: " + settingsXML.PlaylistItems)
 				log.Println("-----		")
 
-				NotifyYouTube(settingsXML.MediaFolderNotify, settingsXML.Config, settingsXML.PodcastsNotifty[i].Name, settingsXML.Config+"youtube-dl-notify.txt", settingsXML.PlaylistItems, settingsXML.PodcastsNotifty[i].YouTubeURL, settingsXML.PodcastsNotifty[i].PushoverAppToken, settingsXML.PushoverUserToken)
+				if WebSubActive(NotifyStateKey(settingsXML.PodcastsNotifty[i].Name)) {
+					log.Println("WebSub subscription active, not polling: " + settingsXML.PodcastsNotifty[i].Name)
+				} else {
+					RunPodcastsNotifty(settingsXML.PodcastsNotifty[i], "")
+				}
 				log.Println("")
 			}
 
@@ -1098,7 +1407,7 @@ func main() {
 	// ####################### Run YT-DLP for TikTok ##########################
 	// ########################################################################
 
-	if validateXML.MediaFolder == true && validateXML.RSSFolder == true && validateXML.RSSTemplate == true && validateXML.Config == true && validateXML.PushoverUserToken == true && validateXML.HTTPHost == true && validateXML.PlaylistItems == true {
+	if validateXML.MediaFolder == true && validateXML.RSSFolder == true && validateXML.RSSTemplate == true && validateXML.Config == true && validateXML.HTTPHost == true && validateXML.PlaylistItems == true {
 		log.Println("-----		")
 		log.Println("-----		Start RSSDownload")
 		log.Println("-----		")
@@ -1182,72 +1491,7 @@ func main() {
 				log.Println("PlaylistItems: " + settingsXML.PlaylistItems)
 				log.Println("-----		")
 
-				// ~~~~~~~~~ Read TikTok RSS Feed ~~~~~~~~~~~
-				err := DownloadFile(settingsXML.Config+"tiktok.json", settingsXML.RSSDownload[i].TikTokFeed+settingsXML.RSSDownload[i].TikTokUsername)
-				if err != nil {
-					panic(err)
-				}
-				log.Println("Downloaded: " + settingsXML.Config + "tiktok.json")
-
-				content, contenterr := ioutil.ReadFile(settingsXML.Config + "tiktok.json")
-				if contenterr != nil {
-					log.Fatal("Error when opening file: ", contenterr)
-				}
-
-				// defining a map
-				var mapresult map[string]interface{}
-				maperr := json.Unmarshal([]byte(content), &mapresult)
-
-				if maperr != nil {
-					// print out if error is not nil
-					// fmt.Println(maperr)
-					log.Fatal("Error reading JSON File ", maperr)
-				}
-
-				var jsonpayload TikTok
-
-				jsonpayload.Icon = fmt.Sprint(mapresult["icon"])
-				jsonpayload.Title = fmt.Sprint(mapresult["title"])
-				// jsonpayload. = fmt.Sprint(mapresult["items"])
-
-				log.Println("icon: " + jsonpayload.Icon)
-				log.Println("title: " + jsonpayload.Title)
-				log.Println("RSSFolder: " + settingsXML.RSSFolder)
-				log.Println("RSSTemplate: " + settingsXML.RSSTemplate)
-				log.Println("HTTPHost: " + settingsXML.HTTPHost)
-				log.Println("Config: " + settingsXML.Config)
-
-				// ~~~~~~~~~~ Loop through Items ~~~~~~~~~~~~
-
-				var jsonitemspayload Entry
-				jsonitemspayload.Link = ""
-				jsonitemspayload.Title = ""
-
-				a, _ := json.Marshal(mapresult["items"])
-				rssitemjson := string(a)
-				var arrresultitem []map[string]interface{}
-				maperrthumb := json.Unmarshal([]byte(rssitemjson), &arrresultitem)
-				if maperrthumb != nil {
-					// print out if error is not nil
-					// fmt.Println(maperr)
-					log.Fatal("Error reading JSON File ", maperrthumb)
-				}
-
-				for j := 0; j < 5; j++ {
-					// log.Printf(fmt.Sprintf(arrresultthumb[i]["id"].(string)))
-					// thumbid := fmt.Sprintf(arrresultitem[i]["id"].(string))
-					// thumburl := fmt.Sprintf(arrresultitem[i]["url"].(string))
-					jsonitemspayload.Title = fmt.Sprintf(arrresultitem[j]["title"].(string))
-					jsonitemspayload.Link = fmt.Sprintf(arrresultitem[j]["url"].(string))
-					log.Println("---  Item " + fmt.Sprint(i) + ": " + settingsXML.Config)
-					log.Println("jsonitemspayload.Title: " + jsonitemspayload.Title)
-					log.Println("jsonitemspayload.Link: " + jsonitemspayload.Link)
-
-					// Run_YTDLP(settingsXML.MediaFolder, settingsXML.Config, settingsXML.RSSDownload[i].Name, settingsXML.RSSDownload[i].DownloadArchive, settingsXML.PlaylistItems, jsonitemspayload.Link)
-
-					Run_YTDLP(settingsXML.MediaFolder, settingsXML.RSSFolder, settingsXML.RSSTemplate, settingsXML.HTTPHost, settingsXML.Config, settingsXML.RSSDownload[i].Name, settingsXML.RSSDownload[i].ChannelID, settingsXML.RSSDownload[i].FileFormat, settingsXML.RSSDownload[i].DownloadArchive, settingsXML.RSSDownload[i].FileQuality, settingsXML.RSSDownload[i].ChannelThumbnail, settingsXML.PlaylistItems, jsonitemspayload.Link, settingsXML.RSSDownload[i].PushoverAppToken, settingsXML.PushoverUserToken)
-					DeleteOldFiles(settingsXML.MediaFolder + settingsXML.RSSDownload[i].ChannelID + "/")
-				}
+				RunRSSDownload(settingsXML.RSSDownload[i])
 
 				// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
@@ -1260,6 +1504,30 @@ func main() {
 		}
 	}
 
+	// ########################################################################
+	// ######################### Export OPML File #############################
+	// ########################################################################
+
+	if validateXML.RSSFolder == true && validateXML.HTTPHost == true {
+		if _, err := ExportOPML(""); err != nil {
+			log.Println("OPML Export Error: " + err.Error())
+		}
+	}
+
+	// ########################################################################
+	// ######################## Send Run Notifications ########################
+	// ########################################################################
+
+	SendRunBatch()
+
+	// ########################################################################
+	// ######################### Send Email Digest ############################
+	// ########################################################################
+
+	if err := SendDigestIfDue(); err != nil {
+		log.Println("Email Digest Error: " + err.Error())
+	}
+
 	// ########################################################################
 	// ########################################################################
 	// ########################################################################
@@ -1,5 +1,5 @@
 # /etc/cron.d/ytdl
 # 
 # go run TEST-Go.go
-go run /opt/DownloadYouTubeGo/DownloadYouTubeGo-1.16/DownloadYouTubeGo.go  >> /proc/1/fd/1;
+/opt/DownloadYouTubeGo/DownloadYouTubeGo  >> /proc/1/fd/1;
 echo "DONE"  >> /proc/1/fd/1;
\ No newline at end of file
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =========================================================
+// ======================= JSON API ========================
+// =========================================================
+
+// settingsMu guards the entry lists of settingsXML. The API replaces them
+// under the write lock and never changes them in place, so a snapshot taken
+// under the read lock stays valid.
+var settingsMu sync.RWMutex
+
+// SettingsSnapshot returns settingsXML as it is now, for code that reads the
+// entry lists while the server may change them.
+func SettingsSnapshot() settings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settingsXML
+}
+
+type APIFeed struct {
+	Kind       string      `json:"kind"`
+	ID         string      `json:"id"`
+	StateKey   string      `json:"state_key"`
+	FeedURL    string      `json:"feed_url,omitempty"`
+	LastRun    time.Time   `json:"last_run"`
+	LastResult string      `json:"last_result,omitempty"`
+	LastError  string      `json:"last_error,omitempty"`
+	Settings   interface{} `json:"settings"`
+}
+
+var apiKinds = []string{"PodcastDownload", "PodcastsNotifty", "RSSDownload"}
+
+func apiKind(s string) string {
+	for _, kind := range apiKinds {
+		if strings.EqualFold(kind, s) {
+			return kind
+		}
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// apiFeeds lists every entry of every kind in settings order.
+func apiFeeds() []APIFeed {
+	state := LoadState()
+	var feeds []APIFeed
+	add := func(kind string, id string, key string, pChannelID string, entry interface{}) {
+		feed := APIFeed{Kind: kind, ID: id, StateKey: key, Settings: entry}
+		if feedState, ok := state.Feeds[key]; ok {
+			feed.LastRun = feedState.LastRun
+			feed.LastResult = feedState.LastResult
+			feed.LastError = feedState.LastError
+		}
+		if pChannelID != "" && IsValid(settingsXML.RSSFolder+RSSFileName(pChannelID)) {
+			feed.FeedURL = FeedURL(RSSFileName(pChannelID))
+		}
+		feeds = append(feeds, feed)
+	}
+	for _, podcast := range settingsXML.PodcastDownload {
+		add("PodcastDownload", podcast.Name, podcast.ChannelID, podcast.ChannelID, podcast)
+	}
+	for _, notify := range settingsXML.PodcastsNotifty {
+		add("PodcastsNotifty", notify.Name, NotifyStateKey(notify.Name), "", notify)
+	}
+	for _, rss := range settingsXML.RSSDownload {
+		add("RSSDownload", rss.Name, rss.ChannelID, rss.ChannelID, rss)
+	}
+	return feeds
+}
+
+// findEntry returns the index of the entry whose Name (or ChannelID) is id.
+func findEntry(kind string, id string) int {
+	switch kind {
+	case "PodcastDownload":
+		for i, podcast := range settingsXML.PodcastDownload {
+			if podcast.Name == id || podcast.ChannelID == id {
+				return i
+			}
+		}
+	case "PodcastsNotifty":
+		for i, notify := range settingsXML.PodcastsNotifty {
+			if notify.Name == id {
+				return i
+			}
+		}
+	case "RSSDownload":
+		for i, rss := range settingsXML.RSSDownload {
+			if rss.Name == id || rss.ChannelID == id {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// decodeEntry reads a settings entry of kind from a JSON request body.
+func decodeEntry(kind string, r *http.Request) (interface{}, string, error) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	switch kind {
+	case "PodcastDownload":
+		var entry YouTubeDownload
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, "", err
+		}
+		if entry.Name == "" || entry.ChannelID == "" || entry.YouTubeURL == "" || entry.DownloadArchive == "" {
+			return nil, "", errors.New("Name, ChannelID, YouTubeURL and DownloadArchive are required")
+		}
+		if entry.FileFormat == "" {
+			entry.FileFormat = "mp4"
+		}
+		if entry.FileQuality == "" {
+			entry.FileQuality = "best"
+		}
+		return entry, entry.Name, nil
+	case "PodcastsNotifty":
+		var entry PodcastsNotifty
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, "", err
+		}
+		if entry.Name == "" || entry.YouTubeURL == "" {
+			return nil, "", errors.New("Name and YouTubeURL are required")
+		}
+		return entry, entry.Name, nil
+	default:
+		var entry RSSDownload
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, "", err
+		}
+		if entry.Name == "" || entry.ChannelID == "" || entry.TikTokFeed == "" || entry.DownloadArchive == "" {
+			return nil, "", errors.New("Name, ChannelID, TikTokFeed and DownloadArchive are required")
+		}
+		return entry, entry.Name, nil
+	}
+}
+
+// putEntry replaces the entry at index, or appends it when index is -1. The
+// lists are copied, snapshots keep the old ones.
+func putEntry(kind string, index int, entry interface{}) {
+	switch kind {
+	case "PodcastDownload":
+		list := append([]YouTubeDownload{}, settingsXML.PodcastDownload...)
+		if index < 0 {
+			list = append(list, entry.(YouTubeDownload))
+		} else {
+			list[index] = entry.(YouTubeDownload)
+		}
+		settingsXML.PodcastDownload = list
+	case "PodcastsNotifty":
+		list := append([]PodcastsNotifty{}, settingsXML.PodcastsNotifty...)
+		if index < 0 {
+			list = append(list, entry.(PodcastsNotifty))
+		} else {
+			list[index] = entry.(PodcastsNotifty)
+		}
+		settingsXML.PodcastsNotifty = list
+	case "RSSDownload":
+		list := append([]RSSDownload{}, settingsXML.RSSDownload...)
+		if index < 0 {
+			list = append(list, entry.(RSSDownload))
+		} else {
+			list[index] = entry.(RSSDownload)
+		}
+		settingsXML.RSSDownload = list
+	}
+}
+
+func removeEntry(kind string, index int) {
+	switch kind {
+	case "PodcastDownload":
+		list := append([]YouTubeDownload{}, settingsXML.PodcastDownload[:index]...)
+		settingsXML.PodcastDownload = append(list, settingsXML.PodcastDownload[index+1:]...)
+	case "PodcastsNotifty":
+		list := append([]PodcastsNotifty{}, settingsXML.PodcastsNotifty[:index]...)
+		settingsXML.PodcastsNotifty = append(list, settingsXML.PodcastsNotifty[index+1:]...)
+	case "RSSDownload":
+		list := append([]RSSDownload{}, settingsXML.RSSDownload[:index]...)
+		settingsXML.RSSDownload = append(list, settingsXML.RSSDownload[index+1:]...)
+	}
+}
+
+// entryArchive is the DownloadArchive an entry needs before main runs it.
+func entryArchive(entry interface{}) string {
+	switch entry := entry.(type) {
+	case YouTubeDownload:
+		return entry.DownloadArchive
+	case RSSDownload:
+		return entry.DownloadArchive
+	}
+	return ""
+}
+
+// saveEntry writes one added or changed entry into settings.xml and creates
+// its download archive.
+func saveEntry(kind string, index int, entry interface{}) error {
+	var err error
+	if index < 0 {
+		err = AppendSettingsEntries(settingsPath, kind, []interface{}{entry})
+	} else {
+		err = ReplaceSettingsEntry(settingsPath, kind, index, entry)
+	}
+	if err != nil {
+		return err
+	}
+	if archive := entryArchive(entry); archive != "" {
+		return CreateDownloadArchive(archive)
+	}
+	return nil
+}
+
+// runEntry starts the entry's run in the background under the run lock, so
+// it never overlaps a cron run or another API run.
+func runEntry(kind string, index int) error {
+	runLock, err := LockFile(lockPath("DownloadYouTubeGo"), false)
+	if err != nil {
+		return err
+	}
+
+	var run func() error
+	switch kind {
+	case "PodcastDownload":
+		podcast := settingsXML.PodcastDownload[index]
+		run = func() error { return RunPodcastDownload(podcast, "") }
+	case "PodcastsNotifty":
+		notify := settingsXML.PodcastsNotifty[index]
+		run = func() error { return RunPodcastsNotifty(notify, "") }
+	case "RSSDownload":
+		rss := settingsXML.RSSDownload[index]
+		run = func() error { return RunRSSDownload(rss) }
+	}
+
+	go func() {
+		defer Unlock(runLock)
+		if err := run(); err != nil {
+			log.Println("API run failed: " + kind + ": " + err.Error())
+		}
+	}()
+	return nil
+}
+
+func apiAuthorized(r *http.Request) bool {
+	given := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		given = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return settingsXML.APIKey != "" && subtle.ConstantTimeCompare([]byte(settingsXML.APIKey), []byte(given)) == 1
+}
+
+// apiHandler serves
+//
+//	GET    feeds                  every entry with its last run
+//	POST   feeds/<kind>           add an entry
+//	GET    feeds/<kind>/<id>      one entry
+//	PUT    feeds/<kind>/<id>      replace an entry
+//	DELETE feeds/<kind>/<id>      remove an entry
+//	POST   feeds/<kind>/<id>/run  run the entry now
+//	GET    feeds/<kind>/<id>/runs run history
+//
+// below <base>api/, where <kind> is PodcastDownload, PodcastsNotifty or
+// RSSDownload and <id> the entry's Name (or ChannelID).
+func apiHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !apiAuthorized(r) {
+			writeAPIError(w, http.StatusUnauthorized, "missing or wrong API key")
+			return
+		}
+
+		var parts []string
+		for _, part := range strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/"), "/") {
+			unescaped, err := url.PathUnescape(part)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			parts = append(parts, unescaped)
+		}
+		if len(parts) == 0 || parts[0] != "feeds" {
+			writeAPIError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		settingsMu.Lock()
+		defer settingsMu.Unlock()
+
+		if len(parts) == 1 {
+			if r.Method != http.MethodGet {
+				writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			writeJSON(w, http.StatusOK, apiFeeds())
+			return
+		}
+
+		kind := apiKind(parts[1])
+		if kind == "" {
+			writeAPIError(w, http.StatusNotFound, "unknown kind "+parts[1])
+			return
+		}
+
+		if len(parts) == 2 {
+			if r.Method != http.MethodPost {
+				writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			entry, name, err := decodeEntry(kind, r)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if findEntry(kind, name) >= 0 {
+				writeAPIError(w, http.StatusConflict, kind+" "+name+" already exists")
+				return
+			}
+			if err := saveEntry(kind, -1, entry); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			putEntry(kind, -1, entry)
+			log.Println("API added " + kind + ": " + name)
+			writeJSON(w, http.StatusCreated, entry)
+			return
+		}
+
+		index := findEntry(kind, parts[2])
+		if index < 0 {
+			writeAPIError(w, http.StatusNotFound, kind+" "+parts[2]+" not found")
+			return
+		}
+		var current APIFeed
+		position := 0
+		for _, feed := range apiFeeds() {
+			if feed.Kind != kind {
+				continue
+			}
+			if position == index {
+				current = feed
+				break
+			}
+			position++
+		}
+
+		if len(parts) == 4 && parts[3] == "run" && r.Method == http.MethodPost {
+			if err := runEntry(kind, index); err == errLocked {
+				writeAPIError(w, http.StatusConflict, "another run is in progress")
+				return
+			} else if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			log.Println("API run started: " + kind + ": " + current.ID)
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "started", "state_key": current.StateKey})
+			return
+		}
+		if len(parts) == 4 && parts[3] == "runs" && r.Method == http.MethodGet {
+			runs := GetFeedState(current.StateKey).Runs
+			if runs == nil {
+				runs = []RunRecord{}
+			}
+			writeJSON(w, http.StatusOK, runs)
+			return
+		}
+		if len(parts) != 3 {
+			writeAPIError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, current)
+		case http.MethodPut:
+			entry, name, err := decodeEntry(kind, r)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if other := findEntry(kind, name); other >= 0 && other != index {
+				writeAPIError(w, http.StatusConflict, kind+" "+name+" already exists")
+				return
+			}
+			if err := saveEntry(kind, index, entry); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			putEntry(kind, index, entry)
+			log.Println("API updated " + kind + ": " + name)
+			writeJSON(w, http.StatusOK, entry)
+		case http.MethodDelete:
+			if err := ReplaceSettingsEntry(settingsPath, kind, index, nil); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			removeEntry(kind, index)
+			log.Println("API removed " + kind + ": " + current.ID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
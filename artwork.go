@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ================== Artwork Caching ======================
+// =========================================================
+
+// artworkFolder holds the cached artwork inside a feed's MediaFolder, so it
+// is served next to the media at <HTTPHost>podcasts/<ChannelID>/artwork/.
+const artworkFolder = "artwork"
+
+// artworkMaxSize stops a wrong URL from filling the disk.
+const artworkMaxSize = 20 << 20
+
+var artworkClient = &http.Client{Timeout: 30 * time.Second}
+
+// ThumbnailCandidates lists the thumbnail URLs of a video, best first. YouTube
+// has maxresdefault as JPEG and as webp, other sites only the info.json one.
+func ThumbnailCandidates(id string, webpageURL string, infoThumbnail string) []string {
+	var candidates []string
+	if strings.Contains(webpageURL, "youtube.com/") || strings.Contains(webpageURL, "youtu.be/") {
+		candidates = append(candidates,
+			"https://i.ytimg.com/vi/"+id+"/maxresdefault.jpg",
+			"https://i.ytimg.com/vi_webp/"+id+"/maxresdefault.webp")
+	}
+	if infoThumbnail != "" && infoThumbnail != "<nil>" {
+		candidates = append(candidates, infoThumbnail)
+	}
+	return candidates
+}
+
+// urlExists checks a URL with a HEAD request.
+func urlExists(rawURL string) bool {
+	resp, err := artworkClient.Head(rawURL)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// BestThumbnailURL is the first candidate that exists, or the last one
+// (the info.json thumbnail) when none answers.
+func BestThumbnailURL(candidates []string) string {
+	for _, candidate := range candidates {
+		if urlExists(candidate) {
+			return candidate
+		}
+	}
+	return lastCandidate(candidates)
+}
+
+func lastCandidate(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[len(candidates)-1]
+}
+
+func artworkDir(pChannelID string) string {
+	return settingsXML.MediaFolder + pChannelID + "/" + artworkFolder + "/"
+}
+
+// cachedArtwork finds an earlier download of name and the URL it came from,
+// saved next to it as name.source.
+func cachedArtwork(pChannelID string, name string) (string, string) {
+	for _, ext := range []string{".jpg", ".png"} {
+		if IsValid(artworkDir(pChannelID) + name + ext) {
+			source, _ := os.ReadFile(artworkDir(pChannelID) + name + ".source")
+			return name + ext, strings.TrimSpace(string(source))
+		}
+	}
+	return "", ""
+}
+
+// downloadArtwork saves one image as dir+name with .jpg or .png. webp, which
+// many podcast apps cannot show, is converted to JPEG with ffmpeg.
+func downloadArtwork(dir string, name string, source string) (string, error) {
+	resp, err := artworkClient.Get(source)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(source + ": " + resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, artworkMaxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(content) > artworkMaxSize {
+		return "", errors.New(source + ": larger than 20MB")
+	}
+
+	switch http.DetectContentType(content) {
+	case "image/jpeg":
+		return name + ".jpg", WriteFileAtomic(dir+name+".jpg", content, 0644)
+	case "image/png":
+		return name + ".png", WriteFileAtomic(dir+name+".png", content, 0644)
+	case "image/webp":
+		webpPath := dir + "." + name + ".webp"
+		if err := os.WriteFile(webpPath, content, 0644); err != nil {
+			return "", err
+		}
+		defer os.Remove(webpPath)
+		tmpPath := dir + "." + name + ".tmp.jpg"
+		out, err := exec.Command("ffmpeg", "-y", "-loglevel", "error", "-i", webpPath, "-q:v", "2", tmpPath).CombinedOutput()
+		if err != nil {
+			os.Remove(tmpPath)
+			return "", errors.New("ffmpeg: " + err.Error() + ": " + strings.TrimSpace(string(out)))
+		}
+		return name + ".jpg", os.Rename(tmpPath, dir+name+".jpg")
+	}
+	return "", errors.New(source + ": not a JPEG, PNG or webp image")
+}
+
+// CacheArtwork downloads the first working candidate once into the feed's
+// artwork folder. It returns the cached file name and the URL it came from,
+// which is empty for an earlier download cached without its source.
+func CacheArtwork(pChannelID string, name string, candidates []string) (string, string, error) {
+	if cached, source := cachedArtwork(pChannelID, name); cached != "" {
+		return cached, source, nil
+	}
+	dir := artworkDir(pChannelID)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", "", err
+	}
+
+	err := errors.New("no artwork URL")
+	for _, candidate := range candidates {
+		var file string
+		file, err = downloadArtwork(dir, name, candidate)
+		if err == nil {
+			log.Println("Artwork cached: " + candidate + " -> " + dir + file)
+			if err := WriteFileAtomic(dir+name+".source", []byte(candidate+"\n"), 0644); err != nil {
+				log.Println("Artwork source not saved: " + err.Error())
+			}
+			return file, candidate, nil
+		}
+		log.Println("Artwork not cached: " + err.Error())
+	}
+	return "", "", err
+}
+
+// ArtworkURL is the HTTPHost URL of a cached artwork file.
+func ArtworkURL(pChannelID string, file string, pOptions FeedOptions) string {
+	return WithToken(settingsXML.HTTPHost+"podcasts/"+pChannelID+"/"+artworkFolder+"/"+file, FeedToken(pChannelID, pOptions))
+}
+
+// Artwork is a feed image: the URL written into the feed, its width when
+// known and the remote URL it came from, for notifications.
+type Artwork struct {
+	URL    string
+	Width  int
+	Source string
+}
+
+// servedArtwork makes the square version of a cached file and returns its
+// HTTPHost URL, or the cached file itself when it cannot be rendered.
+func servedArtwork(pChannelID string, file string, title string, pOptions FeedOptions) (string, int) {
+	square, width, err := SquareArtwork(pChannelID, file, title, pOptions)
+	if err != nil {
+		log.Println("Square artwork not rendered: " + file + ": " + err.Error())
+		return ArtworkURL(pChannelID, file, pOptions), 0
+	}
+	return ArtworkURL(pChannelID, square, pOptions), width
+}
+
+// EpisodeArtwork caches a video's thumbnail and serves it as square artwork,
+// overlaid with pName when ArtworkTitle is set. When no candidate could be
+// downloaded the feed links the info.json thumbnail.
+func EpisodeArtwork(pChannelID string, pName string, id string, candidates []string, pOptions FeedOptions) Artwork {
+	file, source, err := CacheArtwork(pChannelID, id, candidates)
+	if err != nil {
+		remote := lastCandidate(candidates)
+		return Artwork{URL: remote, Source: remote}
+	}
+	if source == "" {
+		source = lastCandidate(candidates)
+	}
+	artwork := Artwork{Source: source}
+	artwork.URL, artwork.Width = servedArtwork(pChannelID, file, pName, pOptions)
+	return artwork
+}
+
+// ChannelArtwork caches a channel image and serves it as square artwork. The
+// file is named after the source URL, so a new avatar or ChannelThumbnail is
+// downloaded again.
+func ChannelArtwork(pChannelID string, pName string, source string, pOptions FeedOptions) (string, error) {
+	sum := sha1.Sum([]byte(source))
+	name := "channel-" + hex.EncodeToString(sum[:])[:12]
+	file, _, err := CacheArtwork(pChannelID, name, []string{source})
+	if err != nil {
+		return "", err
+	}
+
+	// drop the images of earlier sources
+	old, _ := filepath.Glob(artworkDir(pChannelID) + "channel-*")
+	for _, oldFile := range old {
+		if !strings.HasPrefix(filepath.Base(oldFile), name+".") {
+			os.Remove(oldFile)
+		}
+	}
+	served, _ := servedArtwork(pChannelID, file, pName, pOptions)
+	return served, nil
+}
+
+// ArtworkFiles lists the cached and square artwork of a video.
+func ArtworkFiles(fname_noext string) []string {
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(fname_noext), artworkFolder, filepath.Base(fname_noext)+".*"))
+	return files
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+const commandUsage = `Usage: DownloadYouTubeGo [command]
+
+Without a command every PodcastDownload, PodcastsNotifty and RSSDownload entry is run.
+
+Commands:
+  opml-export [group]    write podcasts.opml (or podcasts-<group>.opml) to RSSFolder
+  import <file> [notify|download] [PushoverAppToken]
+                         add PodcastsNotifty (default) or PodcastDownload entries for
+                         every channel in an OPML file or Takeout subscriptions.csv
+  repair-feed [ChannelID...]
+                         merge duplicate items (same GUID) and fix values written by
+                         older versions in every feed, or the given feeds
+  lint-feed [ChannelID|file...]
+                         check feeds against Apple Podcasts / Podcasting 2.0 rules,
+                         exits 1 when any feed has errors
+  rotate-token <ChannelID...>
+                         give private feeds (Private true) a new access token and
+                         rewrite their feed files with it
+  websub <subscribe|unsubscribe>
+                         send WebSub (un)subscribe requests for every PodcastDownload
+                         and PodcastsNotifty channel now (serve renews leases itself)
+  send <URL>             download one video (any URL yt-dlp supports) into the SendFeed feed
+  serve [address]        serve RSSFolder and MediaFolder at the URLs written into the
+                         feeds, the dashboard at <HTTPHost path>dashboard/ (public
+                         feeds only without APIKey) and, with APIKey set, the JSON
+                         API at <HTTPHost path>api/, send-to-feed at
+                         <HTTPHost path>send?url=<URL>&key=<APIKey> and Prometheus
+                         metrics at <HTTPHost path>metrics (bearer token or basic
+                         auth password APIKey)
+                         (address defaults to HTTPListen, then :8080)`
+
+// RunCommand runs a single maintenance command instead of the download loops.
+func RunCommand(command string, args []string) {
+	log.Println("-----		")
+	log.Println("-----		Run Command: " + command + " " + strings.Join(args, " "))
+	log.Println("-----		")
+
+	switch command {
+	case "opml-export":
+		group := ""
+		if len(args) > 0 {
+			group = args[0]
+		}
+		if _, err := ExportOPML(group); err != nil {
+			log.Fatal(err)
+		}
+	case "import":
+		if len(args) < 1 {
+			log.Fatal(commandUsage)
+		}
+		mode := "notify"
+		if len(args) > 1 {
+			mode = args[1]
+		}
+		if mode != "notify" && mode != "download" {
+			log.Fatal("import mode must be notify or download")
+		}
+		pushoverAppToken := ""
+		if len(args) > 2 {
+			pushoverAppToken = args[2]
+		}
+		if err := ImportSubscriptions(args[0], mode, pushoverAppToken); err != nil {
+			log.Fatal(err)
+		}
+	case "repair-feed":
+		for _, feed := range ConfiguredFeeds() {
+			if len(args) > 0 && !containsString(args, feed.ChannelID) {
+				continue
+			}
+			if !IsValid(settingsXML.RSSFolder + RSSFileName(feed.ChannelID)) {
+				continue
+			}
+			if err := RepairFeed(settingsXML.RSSFolder, feed.ChannelID, feed.Options); IsFeedLintError(err) {
+				log.Println(err)
+			} else if err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "lint-feed":
+		var reports []LintReport
+		if len(args) == 0 {
+			for _, feed := range ConfiguredFeeds() {
+				reports = append(reports, LintFeedSet(settingsXML.RSSFolder, feed.ChannelID)...)
+			}
+		}
+		for _, arg := range args {
+			if data, err := os.ReadFile(arg); err == nil {
+				reports = append(reports, LintFeedData(arg, data, map[string]string{}))
+			} else {
+				reports = append(reports, LintFeedSet(settingsXML.RSSFolder, arg)...)
+			}
+		}
+		errorCount := 0
+		for _, report := range reports {
+			report.Log()
+			errorCount += report.Errors()
+		}
+		if errorCount > 0 {
+			os.Exit(1)
+		}
+	case "rotate-token":
+		if len(args) == 0 {
+			log.Fatal(commandUsage)
+		}
+		for _, feed := range ConfiguredFeeds() {
+			if !containsString(args, feed.ChannelID) {
+				continue
+			}
+			if !settingBool(feed.Options.Private) {
+				log.Println("SKIP " + feed.ChannelID + ": not a private feed")
+				continue
+			}
+			if err := RotateFeedToken(settingsXML.RSSFolder, feed.ChannelID, feed.Options); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "websub":
+		if len(args) < 1 || (args[0] != "subscribe" && args[0] != "unsubscribe") {
+			log.Fatal(commandUsage)
+		}
+		for _, target := range WebSubTargets() {
+			if err := WebSubSubscribe(target, args[0]); err != nil {
+				log.Println("WebSub " + args[0] + " failed: " + target.Name + ": " + err.Error())
+			}
+		}
+	case "send":
+		if len(args) < 1 {
+			log.Fatal(commandUsage)
+		}
+		runLock, err := LockFile(lockPath("DownloadYouTubeGo"), true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer Unlock(runLock)
+		LoadRunMetrics()
+		defer SaveRunMetrics()
+		if err := SendToFeed(args[0]); err != nil {
+			fatalRun(err)
+		}
+	case "serve":
+		listen := ""
+		if len(args) > 0 {
+			listen = args[0]
+		}
+		if err := Serve(listen); err != nil {
+			log.Fatal(err)
+		}
+	case "help", "-h", "--help":
+		log.Println(commandUsage)
+	default:
+		log.Println("Unknown command: " + command)
+		log.Println(commandUsage)
+		os.Exit(2)
+	}
+}
+
+// ConfiguredFeed is a PodcastDownload or RSSDownload entry that writes a feed.
+type ConfiguredFeed struct {
+	Name      string
+	ChannelID string
+	Options   FeedOptions
+}
+
+// ConfiguredFeeds lists each feed file once; RSSDownload entries sharing a
+// ChannelID write to the same feed.
+func ConfiguredFeeds() []ConfiguredFeed {
+	current := SettingsSnapshot()
+	var feeds []ConfiguredFeed
+	seen := map[string]bool{}
+	add := func(pName string, pChannelID string, pOptions FeedOptions) {
+		if pChannelID == "" || seen[pChannelID] {
+			return
+		}
+		seen[pChannelID] = true
+		feeds = append(feeds, ConfiguredFeed{Name: pName, ChannelID: pChannelID, Options: pOptions})
+	}
+	for _, podcast := range current.PodcastDownload {
+		add(podcast.Name, podcast.ChannelID, podcast.FeedOptions)
+	}
+	for _, rss := range current.RSSDownload {
+		add(rss.Name, rss.ChannelID, rss.FeedOptions)
+	}
+	if send, ok := SendFeedEntry(); ok {
+		add(send.Name, send.ChannelID, send.FeedOptions)
+	}
+	return feeds
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// =========================================================
+// ======================= Dashboard =======================
+// =========================================================
+
+type DashboardEpisode struct {
+	Title     string
+	Link      string
+	Thumbnail string
+	Published time.Time
+}
+
+type DashboardFeed struct {
+	Kind       string
+	Name       string
+	ChannelID  string
+	FeedURL    string
+	LastRun    time.Time
+	LastResult string
+	LastError  string
+	DiskUsage  int64
+	Episodes   []DashboardEpisode
+}
+
+// dashboardEpisodes is how many of the latest episodes are shown per feed.
+const dashboardEpisodes = 5
+
+const dashboardTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>DownloadYouTubeGo</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 70em; padding: 0 1em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+.ok { color: #2a7a2a; } .failed { color: #b00020; font-weight: bold; }
+.error { color: #b00020; font-family: monospace; white-space: pre-wrap; }
+.episodes { display: flex; gap: .6em; flex-wrap: wrap; }
+.episode { width: 10em; font-size: .85em; }
+.episode img { width: 10em; aspect-ratio: 16/9; object-fit: cover; display: block; }
+</style>
+</head>
+<body>
+<h1>DownloadYouTubeGo</h1>
+<p>{{len .Feeds}} feeds, {{bytes .DiskUsage}} in MediaFolder. Generated {{.Generated.Format "2006-01-02 15:04:05"}}.</p>
+<table>
+<tr><th>Feed</th><th>Type</th><th>Last run</th><th>Result</th><th>Disk</th></tr>
+{{- range .Feeds}}
+<tr>
+<td>{{if .FeedURL}}<a href="{{.FeedURL}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}{{if .ChannelID}}<br><small>{{.ChannelID}}</small>{{end}}</td>
+<td>{{.Kind}}</td>
+<td>{{if .LastRun.IsZero}}never{{else}}{{.LastRun.Format "2006-01-02 15:04"}}{{end}}</td>
+<td class="{{.LastResult}}">{{.LastResult}}{{if .LastError}}<div class="error">{{.LastError}}</div>{{end}}</td>
+<td>{{if .ChannelID}}{{bytes .DiskUsage}}{{end}}</td>
+</tr>
+{{- if .Episodes}}
+<tr><td colspan="5"><div class="episodes">
+{{- range .Episodes}}
+<div class="episode"><a href="{{.Link}}">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="" loading="lazy">{{end}}{{.Title}}</a><br><small>{{if not .Published.IsZero}}{{.Published.Format "2006-01-02"}}{{end}}</small></div>
+{{- end}}
+</div></td></tr>
+{{- end}}
+{{- end}}
+</table>
+</body>
+</html>
+`
+
+var dashboardFuncs = template.FuncMap{
+	"bytes": humanBytes,
+}
+
+func humanBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + units[unit]
+}
+
+// FolderSize adds up the sizes of the files below dir.
+func FolderSize(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// LatestEpisodes reads the newest items of a channel's main feed.
+func LatestEpisodes(sRSSFolder string, pChannelID string, count int) []DashboardEpisode {
+	content, err := os.ReadFile(sRSSFolder + RSSFileName(pChannelID))
+	if err != nil {
+		return nil
+	}
+	doc, err := ParseFeedDocument(string(content))
+	if err != nil {
+		return nil
+	}
+	SortItems(doc.Items)
+
+	var episodes []DashboardEpisode
+	for _, item := range doc.Items {
+		if len(episodes) == count {
+			break
+		}
+		decoded, err := decodeItem(item.XML)
+		if err != nil {
+			continue
+		}
+		// the artwork of private feeds keeps the feed's access token, which
+		// the server needs to serve it; private feeds are only listed behind
+		// the APIKey
+		episodes = append(episodes, DashboardEpisode{Title: decoded.Title, Link: WithToken(decoded.Link, ""), Thumbnail: decoded.Image.Href, Published: item.PubDate})
+	}
+	return episodes
+}
+
+// DashboardFeeds collects every configured entry with its last run. Private
+// feeds are left out unless showPrivate is set.
+func DashboardFeeds(showPrivate bool) []DashboardFeed {
+	current := SettingsSnapshot()
+	state := LoadState()
+	var feeds []DashboardFeed
+
+	addFeed := func(kind string, pName string, pChannelID string, key string, pOptions FeedOptions) {
+		if settingBool(pOptions.Private) && !showPrivate {
+			return
+		}
+		feed := DashboardFeed{Kind: kind, Name: pName, ChannelID: pChannelID}
+		if feedState, ok := state.Feeds[key]; ok {
+			feed.LastRun = feedState.LastRun
+			feed.LastResult = feedState.LastResult
+			feed.LastError = tokenParamRegexp.ReplaceAllString(feedState.LastError, "")
+		}
+		if pChannelID != "" {
+			if IsValid(settingsXML.RSSFolder + RSSFileName(pChannelID)) {
+				feed.FeedURL = FeedURL(RSSFileName(pChannelID))
+			}
+			feed.DiskUsage = FolderSize(settingsXML.MediaFolder + pChannelID)
+			feed.Episodes = LatestEpisodes(settingsXML.RSSFolder, pChannelID, dashboardEpisodes)
+		}
+		feeds = append(feeds, feed)
+	}
+
+	for _, podcast := range current.PodcastDownload {
+		addFeed("PodcastDownload", podcast.Name, podcast.ChannelID, podcast.ChannelID, podcast.FeedOptions)
+	}
+	for _, rss := range current.RSSDownload {
+		addFeed("RSSDownload", rss.Name, rss.ChannelID, rss.ChannelID, rss.FeedOptions)
+	}
+	if send, ok := SendFeedEntry(); ok {
+		addFeed("SendFeed", send.Name, send.ChannelID, send.ChannelID, send.FeedOptions)
+	}
+	for _, notify := range current.PodcastsNotifty {
+		addFeed("PodcastsNotifty", notify.Name, "", NotifyStateKey(notify.Name), FeedOptions{})
+	}
+	return feeds
+}
+
+var dashboardPage = template.Must(template.New("dashboard").Funcs(dashboardFuncs).Parse(dashboardTemplate))
+
+// keyAuthorized accepts the APIKey as for the API, as ?key= or as the
+// password of HTTP basic auth, so a browser can open the page.
+func keyAuthorized(r *http.Request) bool {
+	if sendAuthorized(r) {
+		return true
+	}
+	_, password, ok := r.BasicAuth()
+	return ok && settingsXML.APIKey != "" && subtle.ConstantTimeCompare([]byte(settingsXML.APIKey), []byte(password)) == 1
+}
+
+// requireKey answers 401 and asks the browser for the APIKey.
+func requireKey(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="DownloadYouTubeGo"`)
+	http.Error(w, "missing or wrong API key", http.StatusUnauthorized)
+}
+
+// dashboardHandler needs the APIKey when one is set; without one it shows
+// the public feeds only.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if settingsXML.APIKey != "" && !keyAuthorized(r) {
+		requireKey(w)
+		return
+	}
+	data := struct {
+		Feeds     []DashboardFeed
+		DiskUsage int64
+		Generated time.Time
+	}{
+		Feeds:     DashboardFeeds(settingsXML.APIKey != ""),
+		DiskUsage: FolderSize(settingsXML.MediaFolder),
+		Generated: time.Now(),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := dashboardPage.Execute(w, data); err != nil {
+		log.Println("Dashboard Error: " + err.Error())
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ================== Email Notifications ==================
+// =========================================================
+
+// SMTPConfig is the <SMTP> block of the settings. TLS is starttls (default),
+// tls for implicit TLS on port 465, or none. Mails go to the Email setting
+// unless an email <Notifier> has its own User.
+type SMTPConfig struct {
+	Host     string `xml:"Host"`
+	Port     string `xml:"Port,omitempty"`
+	Username string `xml:"Username,omitempty"`
+	Password string `xml:"Password,omitempty"`
+	TLS      string `xml:"TLS,omitempty"`
+	From     string `xml:"From,omitempty"`
+}
+
+// emailRecipients splits a comma separated address list.
+func emailRecipients(list string) []string {
+	var recipients []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			recipients = append(recipients, address)
+		}
+	}
+	return recipients
+}
+
+func smtpTLSMode(config SMTPConfig) string {
+	switch strings.ToLower(config.TLS) {
+	case "tls", "none":
+		return strings.ToLower(config.TLS)
+	}
+	return "starttls"
+}
+
+func smtpAddress(config SMTPConfig) string {
+	port := config.Port
+	if port == "" {
+		switch smtpTLSMode(config) {
+		case "tls":
+			port = "465"
+		case "none":
+			port = "25"
+		default:
+			port = "587"
+		}
+	}
+	return net.JoinHostPort(config.Host, port)
+}
+
+// buildEmail writes an HTML mail with quoted-printable body.
+func buildEmail(from string, to []string, subject string, htmlBody string) []byte {
+	var msg bytes.Buffer
+	id := make([]byte, 12)
+	rand.Read(id)
+	host := from[strings.LastIndex(from, "@")+1:]
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("Message-ID: <" + hex.EncodeToString(id) + "@" + host + ">\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	body := quotedprintable.NewWriter(&msg)
+	body.Write([]byte(htmlBody))
+	body.Close()
+	return msg.Bytes()
+}
+
+// smtpRootCAs verifies the SMTP server's certificate, nil for the system
+// roots. Tests replace it.
+var smtpRootCAs *x509.CertPool
+
+// SendEmail sends one HTML mail with the SMTP settings.
+func SendEmail(to []string, subject string, htmlBody string) error {
+	if settingsXML.SMTP == nil || settingsXML.SMTP.Host == "" {
+		return errors.New("email needs the <SMTP> settings")
+	}
+	if len(to) == 0 {
+		return errors.New("email needs a recipient in Email")
+	}
+	config := *settingsXML.SMTP
+	from := config.From
+	if from == "" {
+		from = config.Username
+	}
+	if !strings.Contains(from, "@") {
+		return errors.New("email needs an SMTP From address")
+	}
+
+	address := smtpAddress(config)
+	tlsConfig := &tls.Config{ServerName: config.Host, RootCAs: smtpRootCAs}
+	var conn net.Conn
+	var err error
+	if smtpTLSMode(config) == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", address, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if smtpTLSMode(config) == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return errors.New("STARTTLS: " + err.Error())
+		}
+	}
+	if config.Username != "" {
+		// PlainAuth refuses to send the password unencrypted except to localhost
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(buildEmail(from, to, subject, htmlBody)); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// ~~~~~~~~~~~~~~~~~ Episode Emails ~~~~~~~~~~~~~~~~~
+
+var episodeEmailTemplate = template.Must(template.New("episode").Parse(`<html><body style="font-family: sans-serif;">
+<h2>{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h2>
+{{if .ImageURL}}<p><a href="{{.URL}}"><img src="{{.ImageURL}}" alt="" style="max-width: 480px; width: 100%;"></a></p>{{end}}
+{{range .Paragraphs}}<p>{{.}}</p>
+{{end}}</body></html>
+`))
+
+// emailParagraphs splits a plain text message on blank lines.
+func emailParagraphs(message string) []string {
+	var paragraphs []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	return paragraphs
+}
+
+type emailNotifier struct{ config NotifierConfig }
+
+func (p emailNotifier) Name() string { return "email" }
+
+func (p emailNotifier) Notify(n Notification) error {
+	to := p.config.User
+	if to == "" {
+		to = settingsXML.Email
+	}
+	var body bytes.Buffer
+	err := episodeEmailTemplate.Execute(&body, map[string]interface{}{
+		"Title":      n.Title,
+		"URL":        n.URL,
+		"ImageURL":   n.ImageURL,
+		"Paragraphs": emailParagraphs(n.Message),
+	})
+	if err != nil {
+		return err
+	}
+	return SendEmail(emailRecipients(to), n.Title, body.String())
+}
+
+// ~~~~~~~~~~~~~~~~~~ Email Digest ~~~~~~~~~~~~~~~~~~
+
+// DigestEntry is a new download or notify-only upload waiting for the
+// EmailDigest mail.
+type DigestEntry struct {
+	Time     time.Time `json:"time"`
+	Feed     string    `json:"feed"`
+	Kind     string    `json:"kind"`
+	Title    string    `json:"title"`
+	URL      string    `json:"url,omitempty"`
+	ImageURL string    `json:"image_url,omitempty"`
+}
+
+// DigestFile is <Config>emaildigest.json.
+type DigestFile struct {
+	LastSent time.Time     `json:"last_sent"`
+	Entries  []DigestEntry `json:"entries"`
+}
+
+func digestFilePath() string {
+	return settingsXML.Config + "emaildigest.json"
+}
+
+// digestInterval is the EmailDigest setting: daily or weekly.
+func digestInterval() (time.Duration, bool) {
+	switch strings.ToLower(strings.TrimSpace(settingsXML.EmailDigest)) {
+	case "daily":
+		return 24 * time.Hour, true
+	case "weekly":
+		return 7 * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// updateDigest loads the digest file, applies update and writes it back.
+func updateDigest(update func(digest *DigestFile)) error {
+	digestLock, err := LockFile(lockPath("emaildigest"), true)
+	if err != nil {
+		log.Println("Unable to lock " + digestFilePath() + ": " + err.Error())
+	}
+	defer Unlock(digestLock)
+
+	var digest DigestFile
+	content, err := os.ReadFile(digestFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &digest); err != nil {
+			return errors.New("Unable to parse " + digestFilePath() + ": " + err.Error())
+		}
+	}
+	update(&digest)
+	content, err = json.MarshalIndent(digest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(digestFilePath(), content, 0600)
+}
+
+// QueueDigest adds a notification to the next digest when EmailDigest is set.
+func QueueDigest(key string, n Notification) {
+	if _, ok := digestInterval(); !ok {
+		return
+	}
+	entry := DigestEntry{Time: time.Now(), Feed: n.Feed, Kind: "download", Title: n.Title, URL: n.URL, ImageURL: n.ImageURL}
+	if strings.HasPrefix(key, NotifyStateKey("")) {
+		entry.Kind = "upload"
+	}
+	if n.Episode != "" {
+		entry.Title = n.Episode
+	}
+	err := updateDigest(func(digest *DigestFile) {
+		if digest.LastSent.IsZero() {
+			// the first period starts with the first entry
+			digest.LastSent = time.Now()
+		}
+		digest.Entries = append(digest.Entries, entry)
+	})
+	if err != nil {
+		log.Println("Email digest not queued: " + err.Error())
+	}
+}
+
+var digestEmailTemplate = template.Must(template.New("digest").Parse(`<html><body style="font-family: sans-serif;">
+{{range .Sections}}<h2>{{.Heading}} ({{len .Entries}})</h2>
+<table cellpadding="6">
+{{range .Entries}}<tr>
+<td>{{if .ImageURL}}<a href="{{.URL}}"><img src="{{.ImageURL}}" alt="" width="160"></a>{{end}}</td>
+<td><b>{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</b><br>{{.Feed}} &middot; {{.Time.Format "Mon 2 Jan 15:04"}}</td>
+</tr>
+{{end}}</table>
+{{end}}</body></html>
+`))
+
+type digestSection struct {
+	Heading string
+	Entries []DigestEntry
+}
+
+// SendDigestIfDue mails the queued entries once the EmailDigest period since
+// the last digest is over.
+func SendDigestIfDue() error {
+	interval, ok := digestInterval()
+	if !ok {
+		return nil
+	}
+	var due []DigestEntry
+	var lastSent time.Time
+	err := updateDigest(func(digest *DigestFile) {
+		if len(digest.Entries) > 0 && time.Since(digest.LastSent) >= interval {
+			due, lastSent = digest.Entries, digest.LastSent
+			digest.Entries = nil
+			digest.LastSent = time.Now()
+		}
+	})
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	sections := []digestSection{{Heading: "New Downloads"}, {Heading: "New Uploads"}}
+	for _, entry := range due {
+		if entry.Kind == "upload" {
+			sections[1].Entries = append(sections[1].Entries, entry)
+		} else {
+			sections[0].Entries = append(sections[0].Entries, entry)
+		}
+	}
+	var shown []digestSection
+	for _, section := range sections {
+		if len(section.Entries) > 0 {
+			shown = append(shown, section)
+		}
+	}
+	var body bytes.Buffer
+	if err := digestEmailTemplate.Execute(&body, map[string]interface{}{"Sections": shown}); err != nil {
+		return err
+	}
+	subject := "DownloadYouTubeGo " + strings.ToLower(settingsXML.EmailDigest) + " digest: " + strconv.Itoa(len(due)) + " new since " + lastSent.Format("2 Jan")
+
+	if err := SendEmail(emailRecipients(settingsXML.Email), subject, body.String()); err != nil {
+		// keep the entries for the next run
+		updateDigest(func(digest *DigestFile) {
+			digest.Entries = append(due, digest.Entries...)
+			digest.LastSent = lastSent
+		})
+		return err
+	}
+	log.Println("Email digest sent: " + strconv.Itoa(len(due)) + " entries")
+	return nil
+}
+
+// RunEmailDigest sends the digest when it is due while the server runs, for
+// setups without cron runs.
+func RunEmailDigest() {
+	for {
+		if err := SendDigestIfDue(); err != nil {
+			log.Println("Email Digest Error: " + err.Error())
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the fake SMTP server was told.
+type smtpSession struct {
+	TLS  bool
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTP serves one SMTP session per connection, offering STARTTLS with
+// the httptest certificate for 127.0.0.1.
+func fakeSMTP(t *testing.T) (string, <-chan smtpSession) {
+	tlsServer := httptest.NewUnstartedServer(nil)
+	tlsServer.StartTLS()
+	serverTLS := &tls.Config{Certificates: tlsServer.TLS.Certificates}
+	oldRoots := smtpRootCAs
+	smtpRootCAs = x509.NewCertPool()
+	smtpRootCAs.AddCert(tlsServer.Certificate())
+	t.Cleanup(func() { smtpRootCAs = oldRoots; tlsServer.Close() })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sessions := make(chan smtpSession, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, serverTLS, sessions)
+		}
+	}()
+	return listener.Addr().String(), sessions
+}
+
+func serveSMTP(conn net.Conn, serverTLS *tls.Config, sessions chan<- smtpSession) {
+	defer conn.Close()
+	var session smtpSession
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO":
+			if session.TLS {
+				text.PrintfLine("250-fake\r\n250 AUTH PLAIN")
+			} else {
+				text.PrintfLine("250-fake\r\n250-STARTTLS\r\n250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 go ahead")
+			tlsConn := tls.Server(conn, serverTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			session.TLS = true
+		case "AUTH":
+			decoded, _ := base64.StdEncoding.DecodeString(strings.Fields(line)[2])
+			session.Auth = string(decoded)
+			text.PrintfLine("235 ok")
+		case "MAIL":
+			session.From = line
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			session.To = append(session.To, line)
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, _ := text.ReadDotBytes()
+			session.Data = string(data)
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			sessions <- session
+			return
+		default:
+			text.PrintfLine("502 unknown")
+		}
+	}
+}
+
+func useSMTP(t *testing.T, config SMTPConfig) {
+	oldSettings := settingsXML
+	t.Cleanup(func() { settingsXML = oldSettings })
+	settingsXML.SMTP = &config
+}
+
+func TestSendEmail(t *testing.T) {
+	address, sessions := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(address)
+
+	for _, mode := range []string{"starttls", "none"} {
+		useSMTP(t, SMTPConfig{Host: host, Port: port, Username: "me@example.com", Password: "pw", TLS: mode})
+		err := SendEmail([]string{"a@example.com", "b@example.com"}, "Grüße", "<p>Hello</p>")
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		var session smtpSession
+		select {
+		case session = <-sessions:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no session", mode)
+		}
+		if session.TLS != (mode == "starttls") {
+			t.Errorf("%s: TLS = %v", mode, session.TLS)
+		}
+		if session.Auth != "\x00me@example.com\x00pw" {
+			t.Errorf("%s: AUTH = %q", mode, session.Auth)
+		}
+		if session.From != "MAIL FROM:<me@example.com>" && !strings.HasPrefix(session.From, "MAIL FROM:<me@example.com> ") {
+			t.Errorf("%s: %s", mode, session.From)
+		}
+		if len(session.To) != 2 || !strings.Contains(session.To[1], "<b@example.com>") {
+			t.Errorf("%s: recipients %v", mode, session.To)
+		}
+		for _, want := range []string{"To: a@example.com, b@example.com\n", "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\n", "Content-Type: text/html; charset=utf-8\n"} {
+			if !strings.Contains(session.Data, want) {
+				t.Errorf("%s: mail lacks %q:\n%s", mode, want, session.Data)
+			}
+		}
+		if body := mailBody(t, session.Data); strings.TrimSpace(body) != "<p>Hello</p>" {
+			t.Errorf("%s: body = %q", mode, body)
+		}
+	}
+}
+
+func TestSendEmailNeedsSettings(t *testing.T) {
+	useSMTP(t, SMTPConfig{Host: "127.0.0.1", Username: "no-address"})
+	if err := SendEmail([]string{"a@example.com"}, "s", "b"); err == nil || !strings.Contains(err.Error(), "From") {
+		t.Errorf("error = %v, want a missing From", err)
+	}
+	if err := SendEmail(nil, "s", "b"); err == nil {
+		t.Error("sent without recipients")
+	}
+}
+
+func readDigest(t *testing.T) DigestFile {
+	var digest DigestFile
+	content, err := os.ReadFile(digestFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &digest); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestSendDigestIfDueRequeues(t *testing.T) {
+	address, sessions := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(address)
+
+	// a port nobody listens on
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+
+	useSMTP(t, SMTPConfig{Host: host, Port: closedPort, From: "dyg@example.com", TLS: "none"})
+	settingsXML.Config = t.TempDir() + "/"
+	settingsXML.Email = "me@example.com"
+	settingsXML.EmailDigest = "daily"
+
+	QueueDigest("UC1", Notification{Feed: "One", Episode: "First", Title: "RSS Podcast Downloaded (One)"})
+	QueueDigest(NotifyStateKey("Two"), Notification{Feed: "Two", Episode: "Second"})
+	if info, err := os.Stat(digestFilePath()); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("digest file: %v, %v", info, err)
+	}
+	if err := SendDigestIfDue(); err != nil {
+		t.Fatalf("digest sent before the day was over: %v", err)
+	}
+
+	lastSent := time.Now().Add(-25 * time.Hour).Round(time.Second)
+	updateDigest(func(digest *DigestFile) { digest.LastSent = lastSent })
+	if err := SendDigestIfDue(); err == nil {
+		t.Fatal("no error without an SMTP server")
+	}
+	digest := readDigest(t)
+	if len(digest.Entries) != 2 || !digest.LastSent.Equal(lastSent) {
+		t.Fatalf("after the failure: %d entries, last sent %v, want 2 and %v", len(digest.Entries), digest.LastSent, lastSent)
+	}
+
+	settingsXML.SMTP.Port = port
+	if err := SendDigestIfDue(); err != nil {
+		t.Fatal(err)
+	}
+	body := mailBody(t, (<-sessions).Data)
+	for _, want := range []string{"New Downloads (1)", "First", "New Uploads (1)", "Second"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("digest lacks %q", want)
+		}
+	}
+	if digest := readDigest(t); len(digest.Entries) != 0 || time.Since(digest.LastSent) > time.Minute {
+		t.Errorf("after sending: %d entries, last sent %v", len(digest.Entries), digest.LastSent)
+	}
+}
+
+// mailBody decodes the quoted-printable body of a mail.
+func mailBody(t *testing.T, data string) string {
+	split := strings.Index(data, "\n\n")
+	if split < 0 {
+		t.Fatalf("mail without a body:\n%s", data)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(data[split+2:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
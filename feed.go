@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const insertItemsMarker = "<!-- INSERT_ITEMS_HERE -->"
+
+// Layout pubDate was written with before items were dated by upload date.
+const legacyPubDateLayout = "02/01/2006 03:04:05 -0700"
+
+const (
+	atomNamespace    = "http://www.w3.org/2005/Atom"
+	historyNamespace = "http://purl.org/syndication/history/1.0"
+	itunesNamespace  = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+	podcastNamespace = "https://podcastindex.org/namespace/1.0"
+)
+
+// FeedDocument is a generated RSS file split into the channel header, the
+// <item> blocks and everything from the INSERT_ITEMS_HERE marker onwards.
+type FeedDocument struct {
+	Head  string
+	Items []FeedItem
+	Tail  string
+}
+
+type FeedItem struct {
+	XML     string
+	GUID    string
+	MediaID string
+	PubDate time.Time
+}
+
+type rssItemEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type rssItemImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string           `xml:"title"`
+	Description string           `xml:"description"`
+	Link        string           `xml:"link"`
+	GUID        string           `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Author      string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	Image       rssItemImage     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Duration    string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Enclosure   rssItemEnclosure `xml:"enclosure"`
+}
+
+var pagingLinkRegexp = regexp.MustCompile(`[ \t]*<atom:link rel="(self|current|prev-archive|next-archive)"[^>]*/>\n?|[ \t]*<fh:archive/>\n?`)
+
+func parsePubDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, legacyPubDateLayout, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// decodeItem parses a single <item> block. The block is wrapped in an element
+// declaring the itunes and podcast prefixes so it decodes on its own.
+func decodeItem(itemXML string) (rssItem, error) {
+	var wrapper struct {
+		Item rssItem `xml:"item"`
+	}
+	doc := "<wrapper xmlns:itunes=\"" + itunesNamespace + "\" xmlns:podcast=\"" + podcastNamespace + "\" xmlns:atom=\"" + atomNamespace + "\">" + itemXML + "</wrapper>"
+	err := xml.Unmarshal([]byte(doc), &wrapper)
+	return wrapper.Item, err
+}
+
+func NewFeedItem(itemXML string) FeedItem {
+	item := FeedItem{XML: strings.TrimSpace(itemXML)}
+	parsed, err := decodeItem(item.XML)
+	if err != nil {
+		log.Println("Unable to parse RSS item: " + err.Error())
+		return item
+	}
+	item.GUID = strings.TrimSpace(parsed.GUID)
+	item.MediaID = mediaIDFromURL(parsed.Enclosure.URL)
+	item.PubDate, _ = parsePubDate(parsed.PubDate)
+	return item
+}
+
+// mediaIDFromURL returns the video ID an enclosure URL points at, i.e. the
+// file name without extension or query string.
+func mediaIDFromURL(enclosureURL string) string {
+	if enclosureURL == "" {
+		return ""
+	}
+	if i := strings.IndexAny(enclosureURL, "?#"); i >= 0 {
+		enclosureURL = enclosureURL[:i]
+	}
+	base := enclosureURL[strings.LastIndex(enclosureURL, "/")+1:]
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// EpisodeGUID is the GUID written for a downloaded video. It only depends on
+// the video itself, never on HTTPHost or where the media is stored.
+func EpisodeGUID(webpageURL string, pChannelID string, id string) string {
+	if webpageURL != "" && webpageURL != "<nil>" {
+		return webpageURL
+	}
+	return pChannelID + ":" + id
+}
+
+func ParseFeedDocument(data string) (FeedDocument, error) {
+	var doc FeedDocument
+
+	markerIdx := strings.LastIndex(data, insertItemsMarker)
+	if markerIdx < 0 {
+		return doc, errors.New("feed has no " + insertItemsMarker + " marker")
+	}
+
+	headEnd := markerIdx
+	rest := data[:markerIdx]
+	offset := 0
+	for {
+		start := strings.Index(rest[offset:], "<item>")
+		if start < 0 {
+			break
+		}
+		start += offset
+		end := strings.Index(rest[start:], "</item>")
+		if end < 0 {
+			return doc, errors.New("unterminated <item> in feed")
+		}
+		end += start + len("</item>")
+		if len(doc.Items) == 0 {
+			headEnd = start
+		}
+		doc.Items = append(doc.Items, NewFeedItem(rest[start:end]))
+		offset = end
+	}
+
+	doc.Head = strings.TrimRight(data[:headEnd], " \t")
+	if !strings.HasSuffix(doc.Head, "\n") {
+		doc.Head += "\n"
+	}
+	doc.Tail = data[markerIdx:]
+	return doc, nil
+}
+
+func (doc FeedDocument) String() string {
+	var b strings.Builder
+	b.WriteString(doc.Head)
+	for _, item := range doc.Items {
+		b.WriteString("\t\t")
+		b.WriteString(item.XML)
+		b.WriteString("\n")
+	}
+	b.WriteString(doc.Tail)
+	return b.String()
+}
+
+// HasEpisode reports whether an item with the GUID, or an enclosure for the
+// video ID, is already in the feed.
+func (doc FeedDocument) HasEpisode(guid string, id string) bool {
+	for _, item := range doc.Items {
+		if item.GUID != "" && item.GUID == guid {
+			return true
+		}
+		if id != "" && item.MediaID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func itemKey(item FeedItem) string {
+	if item.GUID != "" {
+		return "guid:" + item.GUID
+	}
+	if item.MediaID != "" {
+		return "media:" + item.MediaID
+	}
+	return ""
+}
+
+// DedupeItems merges items sharing a GUID (or, for items without one, an
+// enclosure video ID). The copy with the earliest pubDate is kept, so the
+// episode keeps its original place in the feed.
+func DedupeItems(items []FeedItem) ([]FeedItem, int) {
+	keep := map[string]int{}
+	mediaKeep := map[string]int{}
+	var merged []FeedItem
+	removed := 0
+
+	for _, item := range items {
+		key := itemKey(item)
+		existing, ok := keep[key]
+		if !ok && item.MediaID != "" {
+			existing, ok = mediaKeep[item.MediaID]
+		}
+		if key == "" || !ok {
+			if key != "" {
+				keep[key] = len(merged)
+			}
+			if item.MediaID != "" {
+				mediaKeep[item.MediaID] = len(merged)
+			}
+			merged = append(merged, item)
+			continue
+		}
+
+		removed++
+		// the duplicate's other key points at the same episode
+		if _, seen := keep[key]; !seen {
+			keep[key] = existing
+		}
+		if _, seen := mediaKeep[item.MediaID]; item.MediaID != "" && !seen {
+			mediaKeep[item.MediaID] = existing
+		}
+		kept := merged[existing]
+		log.Println("Duplicate item: " + key)
+		if !item.PubDate.IsZero() && (kept.PubDate.IsZero() || item.PubDate.Before(kept.PubDate)) {
+			merged[existing] = item
+		}
+	}
+	return merged, removed
+}
+
+// RepairFeed removes duplicate items from a channel's feed and archive pages.
+func RepairFeed(sRSSFolder string, pChannelID string, pOptions FeedOptions) error {
+	feedLock := LockFeed(pChannelID)
+	defer Unlock(feedLock)
+
+	doc, err := LoadFeedSet(sRSSFolder, pChannelID)
+	if err != nil {
+		return err
+	}
+
+	var removed int
+	doc.Items, removed = DedupeItems(doc.Items)
+
+	repaired := 0
+	if head := repairExplicit(doc.Head); head != doc.Head {
+		doc.Head = head
+		repaired++
+	}
+	mediaDir := settingsXML.MediaFolder + pChannelID + "/"
+	for i, item := range doc.Items {
+		if fixed, ok := RepairLegacyItem(item, mediaDir); ok {
+			doc.Items[i] = fixed
+			repaired++
+		}
+	}
+
+	if removed == 0 && repaired == 0 {
+		log.Println("Nothing to repair: " + RSSFileName(pChannelID))
+		return nil
+	}
+
+	log.Println("Removed " + strconv.Itoa(removed) + " duplicate items, repaired " + strconv.Itoa(repaired) + " legacy values: " + RSSFileName(pChannelID))
+	return WriteFeedSet(sRSSFolder, pChannelID, doc, pOptions)
+}
+
+var (
+	legacyExplicitRegexp  = regexp.MustCompile(`(?i)<itunes:explicit>\s*(yes|no|clean|explicit)\s*</itunes:explicit>`)
+	legacyChaptersRegexp  = regexp.MustCompile(`[ \t]*<podcast:chapters url="\[ITEM_CHAPTER_URL\]"[^>]*/>\n?`)
+	enclosureLengthRegexp = regexp.MustCompile(`(<enclosure\b[^>]*\blength=")[^"]*(")`)
+)
+
+// repairExplicit rewrites the itunes:explicit values of old feeds ("No",
+// "Yes") to the "true" / "false" Apple Podcasts expects.
+func repairExplicit(s string) string {
+	return legacyExplicitRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		value := strings.ToLower(legacyExplicitRegexp.FindStringSubmatch(match)[1])
+		if value == "yes" || value == "explicit" {
+			return "<itunes:explicit>true</itunes:explicit>"
+		}
+		return "<itunes:explicit>false</itunes:explicit>"
+	})
+}
+
+// RepairLegacyItem fixes the values items were written with before feeds were
+// linted: a yes/no itunes:explicit, the unreplaced [ITEM_CHAPTER_URL]
+// chapters and the duration used as enclosure length, which is replaced by
+// the size of the media file in mediaDir while it is still there.
+func RepairLegacyItem(item FeedItem, mediaDir string) (FeedItem, bool) {
+	repaired := repairExplicit(item.XML)
+	repaired = legacyChaptersRegexp.ReplaceAllString(repaired, "")
+
+	if parsed, err := decodeItem(repaired); err == nil && item.MediaID != "" {
+		if length, err := strconv.ParseInt(parsed.Enclosure.Length, 10, 64); err != nil || length <= 0 {
+			enclosurePath := parsed.Enclosure.URL
+			if i := strings.IndexAny(enclosurePath, "?#"); i >= 0 {
+				enclosurePath = enclosurePath[:i]
+			}
+			if size := fileSize(mediaDir + item.MediaID + filepath.Ext(enclosurePath)); size != "0" {
+				repaired = enclosureLengthRegexp.ReplaceAllString(repaired, "${1}"+size+"${2}")
+			} else {
+				log.Println("No media file for the enclosure length of item " + item.MediaID)
+			}
+		}
+	}
+
+	if repaired == item.XML {
+		return item, false
+	}
+	return NewFeedItem(repaired), true
+}
+
+// SortItems orders items newest first. Items without a readable pubDate keep
+// their relative order at the end.
+func SortItems(items []FeedItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].PubDate.IsZero() || items[j].PubDate.IsZero() {
+			return !items[i].PubDate.IsZero() && items[j].PubDate.IsZero()
+		}
+		return items[i].PubDate.After(items[j].PubDate)
+	})
+}
+
+// =========================================================
+// ================== Paged / Archived Feeds ===============
+// =========================================================
+
+func settingInt(s string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func RSSFileName(pChannelID string) string {
+	return pChannelID + "RSS.xml"
+}
+
+func ArchiveFileName(pChannelID string, page int) string {
+	return pChannelID + "RSS-archive-" + strconv.Itoa(page) + ".xml"
+}
+
+// FeedURL is the public URL of a file generated into RSSFolder.
+func FeedURL(fileName string) string {
+	return settingsXML.HTTPHost + settingsXML.RSSPath + fileName
+}
+
+func ListArchiveFiles(sRSSFolder string, pChannelID string) []string {
+	var archives []string
+	for page := 1; ; page++ {
+		archivePath := sRSSFolder + ArchiveFileName(pChannelID, page)
+		if !IsValid(archivePath) {
+			break
+		}
+		archives = append(archives, archivePath)
+	}
+	return archives
+}
+
+// LoadFeedSet reads the main feed and every archive page for a channel and
+// returns the main document with all archived items merged back in.
+func LoadFeedSet(sRSSFolder string, pChannelID string) (FeedDocument, error) {
+	content, err := os.ReadFile(sRSSFolder + RSSFileName(pChannelID))
+	if err != nil {
+		return FeedDocument{}, err
+	}
+	doc, err := ParseFeedDocument(string(content))
+	if err != nil {
+		return doc, err
+	}
+
+	for _, archivePath := range ListArchiveFiles(sRSSFolder, pChannelID) {
+		archiveContent, err := os.ReadFile(archivePath)
+		if err != nil {
+			return doc, err
+		}
+		archive, err := ParseFeedDocument(string(archiveContent))
+		if err != nil {
+			return doc, fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+		}
+		doc.Items = append(doc.Items, archive.Items...)
+	}
+	return doc, nil
+}
+
+func ensureNamespace(head string, prefix string, uri string) string {
+	rssStart := strings.Index(head, "<rss")
+	if rssStart < 0 || strings.Contains(head, "xmlns:"+prefix+"=") {
+		return head
+	}
+	insertAt := rssStart + len("<rss")
+	return head[:insertAt] + " xmlns:" + prefix + "=\"" + uri + "\"" + head[insertAt:]
+}
+
+// withPagingLinks replaces the RFC 5005 links in a channel header.
+func withPagingLinks(head string, links []string) string {
+	head = pagingLinkRegexp.ReplaceAllString(head, "")
+	if len(links) == 0 {
+		return head
+	}
+	head = ensureNamespace(head, "atom", atomNamespace)
+	head = ensureNamespace(head, "fh", historyNamespace)
+
+	channelStart := strings.Index(head, "<channel>")
+	if channelStart < 0 {
+		return head
+	}
+	insertAt := channelStart + len("<channel>")
+	block := ""
+	for _, link := range links {
+		block += "\n\t\t" + link
+	}
+	return head[:insertAt] + block + head[insertAt:]
+}
+
+func atomLink(rel string, href string) string {
+	return "<atom:link rel=\"" + rel + "\" href=\"" + strings.ReplaceAll(href, "&", "&amp;") + "\"/>"
+}
+
+// WriteFeedSet sorts the items newest first, keeps at most MaxItems in the
+// main feed and pages the rest into RFC 5005 archive documents, oldest first,
+// so that full archive pages never change once written.
+func WriteFeedSet(sRSSFolder string, pChannelID string, doc FeedDocument, pOptions FeedOptions) error {
+	token := FeedToken(pChannelID, pOptions)
+	doc = ApplyFeedToken(doc, token)
+	SortItems(doc.Items)
+
+	maxItems := settingInt(pOptions.MaxItems, 0)
+	pageSize := settingInt(pOptions.PageSize, maxItems)
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+
+	mainDoc := doc
+	var pages [][]FeedItem
+	if maxItems > 0 && len(doc.Items) > maxItems {
+		mainDoc.Items = doc.Items[:maxItems]
+		older := doc.Items[maxItems:]
+		// oldest first, so page 1 holds the start of the back catalogue
+		for i := len(older) - 1; i >= 0; i -= pageSize {
+			var page []FeedItem
+			for j := i; j >= 0 && j > i-pageSize; j-- {
+				page = append(page, older[j])
+			}
+			SortItems(page)
+			pages = append(pages, page)
+		}
+	}
+
+	mainURL := WithToken(FeedURL(RSSFileName(pChannelID)), token)
+	var mainLinks []string
+	if len(pages) > 0 {
+		mainLinks = append(mainLinks, atomLink("self", mainURL), atomLink("prev-archive", WithToken(FeedURL(ArchiveFileName(pChannelID, len(pages))), token)))
+	}
+	mainDoc.Head = withPagingLinks(doc.Head, mainLinks)
+	if err := WriteFileAtomic(sRSSFolder+RSSFileName(pChannelID), []byte(mainDoc.String()), 0666); err != nil {
+		return err
+	}
+
+	for i, page := range pages {
+		pageNumber := i + 1
+		links := []string{
+			"<fh:archive/>",
+			atomLink("self", WithToken(FeedURL(ArchiveFileName(pChannelID, pageNumber)), token)),
+			atomLink("current", mainURL),
+		}
+		if pageNumber > 1 {
+			links = append(links, atomLink("prev-archive", WithToken(FeedURL(ArchiveFileName(pChannelID, pageNumber-1)), token)))
+		}
+		if pageNumber < len(pages) {
+			links = append(links, atomLink("next-archive", WithToken(FeedURL(ArchiveFileName(pChannelID, pageNumber+1)), token)))
+		}
+		archiveDoc := FeedDocument{Head: withPagingLinks(doc.Head, links), Items: page, Tail: doc.Tail}
+		if err := WriteFileAtomic(sRSSFolder+ArchiveFileName(pChannelID, pageNumber), []byte(archiveDoc.String()), 0666); err != nil {
+			return err
+		}
+	}
+
+	// ---- Remove Stale Pages ---
+	for page := len(pages) + 1; IsValid(sRSSFolder + ArchiveFileName(pChannelID, page)); page++ {
+		log.Println("DELETE FILE: " + sRSSFolder + ArchiveFileName(pChannelID, page))
+		os.Remove(sRSSFolder + ArchiveFileName(pChannelID, page))
+	}
+
+	log.Println("Feed written: " + RSSFileName(pChannelID) + " (" + strconv.Itoa(len(mainDoc.Items)) + " items, " + strconv.Itoa(len(pages)) + " archive pages)")
+
+	if err := WriteAlternateFeeds(sRSSFolder, pChannelID, mainDoc, pOptions); err != nil {
+		return err
+	}
+	return CheckFeed(sRSSFolder, pChannelID)
+}
+
+// =========================================================
+// ================= Channel Header Refresh ================
+// =========================================================
+
+// ChannelRefreshDue reports whether the channel header of an existing feed
+// should be rebuilt: the refresh interval has passed, or the Name or
+// ChannelThumbnail in the settings no longer match what was last written.
+// RSSDownload entries sharing a ChannelID share one header, which belongs to
+// the first of them; the others never refresh it, so their Names don't flip
+// the saved one back and forth.
+func ChannelRefreshDue(pChannelID string, pName string, pChannelThumbnail string, pOptions FeedOptions) bool {
+	for _, feed := range ConfiguredFeeds() {
+		if feed.ChannelID == pChannelID && feed.Name != pName {
+			return false
+		}
+	}
+	hours := settingInt(settingsXML.ChannelRefreshHours, 24)
+	if pOptions.ChannelRefreshHours != "" {
+		hours = settingInt(pOptions.ChannelRefreshHours, hours)
+	}
+
+	feedState := GetFeedState(pChannelID)
+	if feedState.Name != pName || feedState.ChannelThumbnail != pChannelThumbnail {
+		log.Println("Channel settings changed since last refresh: " + pChannelID)
+		return true
+	}
+	if hours <= 0 {
+		return false
+	}
+	return time.Since(feedState.ChannelRefreshed) > time.Duration(hours)*time.Hour
+}
+
+func MarkChannelRefreshed(pChannelID string, pName string, pChannelThumbnail string) {
+	UpdateFeedState(pChannelID, func(feed *FeedState) {
+		feed.ChannelRefreshed = time.Now()
+		feed.Name = pName
+		feed.ChannelThumbnail = pChannelThumbnail
+	})
+}
+
+// RefreshChannelHeader re-renders the channel template with fresh channel data
+// and swaps it in above the existing items, keeping every item and archive page.
+func RefreshChannelHeader(sRSSFolder string, templatePath string, channel ChannelData, pChannelThumbnail string, pOptions FeedOptions) error {
+	rendered, err := RenderChannelTemplate(templatePath, channel)
+	if err != nil {
+		return err
+	}
+	fresh, err := ParseFeedDocument(rendered)
+	if err != nil {
+		return fmt.Errorf("%s: %w", templatePath, err)
+	}
+
+	feedLock := LockFeed(channel.ChannelID)
+	defer Unlock(feedLock)
+
+	doc, err := LoadFeedSet(sRSSFolder, channel.ChannelID)
+	if err != nil {
+		return err
+	}
+	doc.Head = fresh.Head
+	doc.Tail = fresh.Tail
+	// a header that fails lint is still written, so it counts as refreshed
+	writeErr := WriteFeedSet(sRSSFolder, channel.ChannelID, doc, pOptions)
+	if writeErr != nil && !IsFeedLintError(writeErr) {
+		return writeErr
+	}
+
+	MarkChannelRefreshed(channel.ChannelID, channel.Name, pChannelThumbnail)
+	log.Println("Channel header refreshed: " + RSSFileName(channel.ChannelID))
+	return writeErr
+}
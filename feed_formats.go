@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ============ Atom 1.0 and JSON Feed 1.1 Output ==========
+// =========================================================
+
+type rssChannelImage struct {
+	URL string `xml:"url"`
+}
+
+type rssChannel struct {
+	Title       string          `xml:"title"`
+	Link        string          `xml:"link"`
+	Description string          `xml:"description"`
+	Author      string          `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	ItunesImage rssItemImage    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Image       rssChannelImage `xml:"image"`
+}
+
+type rssDocument struct {
+	Channel rssChannel `xml:"channel"`
+}
+
+type atomLinkElement struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string            `xml:"id"`
+	Title     string            `xml:"title"`
+	Updated   string            `xml:"updated"`
+	Published string            `xml:"published,omitempty"`
+	Author    *atomPerson       `xml:"author,omitempty"`
+	Links     []atomLinkElement `xml:"link"`
+	Summary   *atomText         `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name          `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string            `xml:"id"`
+	Title    string            `xml:"title"`
+	Subtitle string            `xml:"subtitle,omitempty"`
+	Updated  string            `xml:"updated"`
+	Icon     string            `xml:"icon,omitempty"`
+	Logo     string            `xml:"logo,omitempty"`
+	Author   atomPerson        `xml:"author"`
+	Links    []atomLinkElement `xml:"link"`
+	Entries  []atomEntry       `xml:"entry"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL               string `json:"url"`
+	MimeType          string `json:"mime_type"`
+	SizeInBytes       int64  `json:"size_in_bytes,omitempty"`
+	DurationInSeconds int    `json:"duration_in_seconds,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title"`
+	ContentText   string               `json:"content_text"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+func AtomFileName(pChannelID string) string {
+	return pChannelID + "Atom.xml"
+}
+
+func JSONFeedFileName(pChannelID string) string {
+	return pChannelID + "Feed.json"
+}
+
+func settingBool(s string) bool {
+	b, _ := strconv.ParseBool(strings.TrimSpace(s))
+	return b
+}
+
+// durationSeconds converts an itunes:duration value ("1:02:03", "62:03" or
+// "3723") to seconds.
+func durationSeconds(s string) int {
+	seconds := 0
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+func ParseChannel(doc FeedDocument) (rssChannel, error) {
+	var rss rssDocument
+	err := xml.Unmarshal([]byte(doc.String()), &rss)
+	if rss.Channel.Image.URL == "" {
+		rss.Channel.Image.URL = rss.Channel.ItunesImage.Href
+	}
+	return rss.Channel, err
+}
+
+// feedItemID is the item's GUID, else its link, else its enclosure URL
+// without the access token, since both Atom and JSON Feed require a
+// non-empty id.
+func feedItemID(item rssItem) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	return WithToken(item.Enclosure.URL, "")
+}
+
+// WriteAlternateFeeds writes the Atom and JSON Feed versions of the main feed
+// when they are enabled for the feed.
+func WriteAlternateFeeds(sRSSFolder string, pChannelID string, doc FeedDocument, pOptions FeedOptions) error {
+	writeAtom := settingBool(pOptions.Atom)
+	writeJSON := settingBool(pOptions.JSONFeed)
+	if !writeAtom && !writeJSON {
+		return nil
+	}
+
+	channel, err := ParseChannel(doc)
+	if err != nil {
+		return err
+	}
+
+	var items []rssItem
+	var dates []time.Time
+	for _, feedItem := range doc.Items {
+		item, err := decodeItem(feedItem.XML)
+		if err != nil {
+			log.Println("Skipping unreadable item: " + err.Error())
+			continue
+		}
+		items = append(items, item)
+		dates = append(dates, feedItem.PubDate)
+	}
+
+	updated := time.Now()
+	if len(dates) > 0 && !dates[0].IsZero() {
+		updated = dates[0]
+	}
+
+	if writeAtom {
+		feed := atomFeed{
+			ID:       FeedURL(AtomFileName(pChannelID)),
+			Title:    channel.Title,
+			Subtitle: channel.Description,
+			Updated:  updated.Format(time.RFC3339),
+			Icon:     channel.Image.URL,
+			Logo:     channel.Image.URL,
+			Author:   atomPerson{Name: channel.Title, URI: channel.Link},
+			Links: []atomLinkElement{
+				{Rel: "self", Href: WithToken(FeedURL(AtomFileName(pChannelID)), FeedToken(pChannelID, pOptions)), Type: "application/atom+xml"},
+			},
+		}
+		if channel.Link != "" {
+			feed.Links = append(feed.Links, atomLinkElement{Rel: "alternate", Href: channel.Link})
+		}
+		for i, item := range items {
+			entry := atomEntry{
+				ID:      feedItemID(item),
+				Title:   item.Title,
+				Updated: updated.Format(time.RFC3339),
+			}
+			if !dates[i].IsZero() {
+				entry.Updated = dates[i].Format(time.RFC3339)
+				entry.Published = entry.Updated
+			}
+			if item.Author != "" {
+				entry.Author = &atomPerson{Name: item.Author}
+			}
+			if item.Link != "" {
+				entry.Links = append(entry.Links, atomLinkElement{Rel: "alternate", Href: item.Link})
+			}
+			if item.Enclosure.URL != "" {
+				enclosure := atomLinkElement{Rel: "enclosure", Href: item.Enclosure.URL, Type: item.Enclosure.Type}
+				if _, err := strconv.ParseInt(item.Enclosure.Length, 10, 64); err == nil {
+					enclosure.Length = item.Enclosure.Length
+				}
+				entry.Links = append(entry.Links, enclosure)
+			}
+			if item.Description != "" {
+				entry.Summary = &atomText{Type: "text", Body: item.Description}
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		atomData, err := xml.MarshalIndent(feed, "", "\t")
+		if err != nil {
+			return err
+		}
+		if err := WriteFileAtomic(sRSSFolder+AtomFileName(pChannelID), append([]byte(xml.Header), atomData...), 0666); err != nil {
+			return err
+		}
+		log.Println("Feed written: " + AtomFileName(pChannelID))
+	}
+
+	if writeJSON {
+		feed := jsonFeed{
+			Version:     "https://jsonfeed.org/version/1.1",
+			Title:       channel.Title,
+			HomePageURL: channel.Link,
+			FeedURL:     WithToken(FeedURL(JSONFeedFileName(pChannelID)), FeedToken(pChannelID, pOptions)),
+			Description: channel.Description,
+			Icon:        channel.Image.URL,
+			Items:       []jsonFeedItem{},
+		}
+		if channel.Title != "" {
+			feed.Authors = []jsonFeedAuthor{{Name: channel.Title, URL: channel.Link}}
+		}
+		for i, item := range items {
+			jsonItem := jsonFeedItem{
+				ID:          feedItemID(item),
+				URL:         item.Link,
+				Title:       item.Title,
+				ContentText: item.Description,
+				Image:       item.Image.Href,
+			}
+			if !dates[i].IsZero() {
+				jsonItem.DatePublished = dates[i].Format(time.RFC3339)
+			}
+			if item.Author != "" {
+				jsonItem.Authors = []jsonFeedAuthor{{Name: item.Author}}
+			}
+			if item.Enclosure.URL != "" {
+				attachment := jsonFeedAttachment{URL: item.Enclosure.URL, MimeType: item.Enclosure.Type, DurationInSeconds: durationSeconds(item.Duration)}
+				attachment.SizeInBytes, _ = strconv.ParseInt(item.Enclosure.Length, 10, 64)
+				jsonItem.Attachments = []jsonFeedAttachment{attachment}
+			}
+			feed.Items = append(feed.Items, jsonItem)
+		}
+
+		var jsonData bytes.Buffer
+		encoder := json.NewEncoder(&jsonData)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "\t")
+		if err := encoder.Encode(feed); err != nil {
+			return err
+		}
+		if err := WriteFileAtomic(sRSSFolder+JSONFeedFileName(pChannelID), jsonData.Bytes(), 0666); err != nil {
+			return err
+		}
+		log.Println("Feed written: " + JSONFeedFileName(pChannelID))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDedupeItems(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	item := func(xml string, guid string, mediaID string, pubDate time.Time) FeedItem {
+		return FeedItem{XML: xml, GUID: guid, MediaID: mediaID, PubDate: pubDate}
+	}
+
+	tests := []struct {
+		name    string
+		items   []FeedItem
+		want    []string
+		removed int
+	}{
+		{
+			name:  "no duplicates",
+			items: []FeedItem{item("a", "g1", "m1", day(2)), item("b", "g2", "m2", day(1))},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:    "same GUID keeps the earliest copy in place",
+			items:   []FeedItem{item("new", "g1", "", day(3)), item("b", "g2", "", day(2)), item("old", "g1", "", day(1))},
+			want:    []string{"old", "b"},
+			removed: 1,
+		},
+		{
+			name:    "same MediaID without GUIDs",
+			items:   []FeedItem{item("a", "", "m1", day(1)), item("b", "", "m1", day(2))},
+			want:    []string{"a"},
+			removed: 1,
+		},
+		{
+			name:    "same MediaID with different GUIDs",
+			items:   []FeedItem{item("a", "g1", "m1", day(2)), item("b", "g2", "m1", day(1))},
+			want:    []string{"b"},
+			removed: 1,
+		},
+		{
+			name:    "same GUID and MediaID",
+			items:   []FeedItem{item("a", "g1", "m1", day(1)), item("b", "g1", "m1", day(1)), item("c", "g1", "m1", time.Time{})},
+			want:    []string{"a"},
+			removed: 2,
+		},
+		{
+			name:    "GUID match links the other MediaID",
+			items:   []FeedItem{item("a", "g1", "m1", day(1)), item("b", "g1", "m2", day(2)), item("c", "g3", "m2", day(3))},
+			want:    []string{"a"},
+			removed: 2,
+		},
+		{
+			name:  "items without GUID or MediaID are kept",
+			items: []FeedItem{item("a", "", "", day(1)), item("b", "", "", day(1))},
+			want:  []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, removed := DedupeItems(test.items)
+			if removed != test.removed {
+				t.Errorf("removed = %d, want %d", removed, test.removed)
+			}
+			var got []string
+			for _, item := range merged {
+				got = append(got, item.XML)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("items = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("items = %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRepairLegacyItem(t *testing.T) {
+	mediaDir := t.TempDir() + "/"
+	os.WriteFile(mediaDir+"abc.mp4", []byte("0123456789"), 0666)
+
+	legacy := "<item>\n\t\t\t<title>Old</title>\n\t\t\t<guid isPermaLink=\"false\">https://www.youtube.com/watch?v=abc</guid>\n" +
+		"\t\t\t<podcast:chapters url=\"[ITEM_CHAPTER_URL]\" type=\"application/json\"/>\n" +
+		"\t\t\t<itunes:explicit>No</itunes:explicit>\n" +
+		"\t\t\t<enclosure url=\"https://example.com/podcasts/UC1/abc.mp4\" type=\"video/mpeg\" length=\"12:34\"/>\n\t\t</item>"
+	fixed, ok := RepairLegacyItem(NewFeedItem(legacy), mediaDir)
+	if !ok {
+		t.Fatal("legacy item not repaired")
+	}
+	want := "<item>\n\t\t\t<title>Old</title>\n\t\t\t<guid isPermaLink=\"false\">https://www.youtube.com/watch?v=abc</guid>\n" +
+		"\t\t\t<itunes:explicit>false</itunes:explicit>\n" +
+		"\t\t\t<enclosure url=\"https://example.com/podcasts/UC1/abc.mp4\" type=\"video/mpeg\" length=\"10\"/>\n\t\t</item>"
+	if fixed.XML != want {
+		t.Errorf("repaired item:\n%s\nwant:\n%s", fixed.XML, want)
+	}
+	if fixed.MediaID != "abc" || fixed.GUID != "https://www.youtube.com/watch?v=abc" {
+		t.Errorf("repaired item lost its keys: %+v", fixed)
+	}
+
+	if _, ok := RepairLegacyItem(fixed, mediaDir); ok {
+		t.Error("a current item was changed")
+	}
+	if got := repairExplicit("<itunes:explicit>Yes</itunes:explicit>"); got != "<itunes:explicit>true</itunes:explicit>" {
+		t.Errorf("repairExplicit(Yes) = %q", got)
+	}
+}
+
+func TestFeedItemID(t *testing.T) {
+	tests := []struct {
+		name string
+		item rssItem
+		want string
+	}{
+		{"guid", rssItem{GUID: "g1", Link: "https://example.com/1"}, "g1"},
+		{"link without guid", rssItem{Link: "https://example.com/1"}, "https://example.com/1"},
+		{"enclosure without the token", rssItem{Enclosure: rssItemEnclosure{URL: "https://example.com/podcasts/UC1/abc.mp4?token=secret"}}, "https://example.com/podcasts/UC1/abc.mp4"},
+	}
+	for _, tt := range tests {
+		if got := feedItemID(tt.item); got != tt.want {
+			t.Errorf("%s: feedItemID = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
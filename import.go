@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ========== OPML / YouTube Subscriptions Import ==========
+// =========================================================
+
+type ImportedChannel struct {
+	Title     string
+	URL       string
+	ChannelID string
+}
+
+var channelIDRegexp = regexp.MustCompile(`UC[0-9A-Za-z_-]{22}`)
+var canonicalChannelRegexp = regexp.MustCompile(`<link rel="canonical" href="https://www\.youtube\.com/channel/(UC[0-9A-Za-z_-]{22})"`)
+var pageChannelRegexp = regexp.MustCompile(`"(?:channelId|externalId)":"(UC[0-9A-Za-z_-]{22})"`)
+var channelIDNameRegexp = regexp.MustCompile(`[^0-9A-Za-z]+`)
+
+// resolveClient keeps a hanging channel page from stalling the run that
+// resolves it.
+var resolveClient = &http.Client{Timeout: 30 * time.Second}
+
+// channelIDFromURL finds the UC… channel ID in a channel or feed URL without
+// going to the network.
+func channelIDFromURL(rawURL string) string {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	if id := parsed.Query().Get("channel_id"); channelIDRegexp.MatchString(id) {
+		return id
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "channel" && channelIDRegexp.MatchString(segments[i+1]) {
+			return segments[i+1]
+		}
+	}
+	return ""
+}
+
+// ResolveChannelID returns the UC… channel ID for any YouTube channel URL,
+// reading the channel page for /@handle, /c/ and /user/ style URLs.
+func ResolveChannelID(rawURL string) (string, error) {
+	if id := channelIDFromURL(rawURL); id != "" {
+		return id, nil
+	}
+	if rawURL == "" {
+		return "", errors.New("no channel URL")
+	}
+
+	log.Println("Resolve Channel ID: " + rawURL)
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	// skip the EU consent interstitial
+	req.Header.Set("Cookie", "CONSENT=YES+1")
+	resp, err := resolveClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", err
+	}
+	if match := canonicalChannelRegexp.FindSubmatch(page); match != nil {
+		return string(match[1]), nil
+	}
+	if match := pageChannelRegexp.FindSubmatch(page); match != nil {
+		return string(match[1]), nil
+	}
+	return "", errors.New("no channel ID found at " + rawURL + " (" + resp.Status + ")")
+}
+
+func ReadOPMLChannels(content []byte) ([]ImportedChannel, error) {
+	var opml OPML
+	if err := xml.Unmarshal(content, &opml); err != nil {
+		return nil, err
+	}
+
+	var channels []ImportedChannel
+	var walk func(outlines []OPMLOutline)
+	walk = func(outlines []OPMLOutline) {
+		for _, outline := range outlines {
+			walk(outline.Outlines)
+			if outline.XMLURL == "" && outline.HTMLURL == "" {
+				continue
+			}
+			channel := ImportedChannel{Title: outline.Title, URL: outline.HTMLURL}
+			if channel.Title == "" {
+				channel.Title = outline.Text
+			}
+			channel.ChannelID = channelIDFromURL(outline.XMLURL)
+			if channel.ChannelID == "" {
+				channel.ChannelID = channelIDFromURL(outline.HTMLURL)
+			}
+			if channel.URL == "" && channel.ChannelID == "" {
+				channel.URL = outline.XMLURL
+			}
+			channels = append(channels, channel)
+		}
+	}
+	walk(opml.Body)
+	return channels, nil
+}
+
+// ReadTakeoutChannels reads subscriptions.csv from a YouTube Takeout export
+// (Channel Id,Channel Url,Channel Title).
+func ReadTakeoutChannels(content []byte) ([]ImportedChannel, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []ImportedChannel
+	for i, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		if i == 0 && !channelIDRegexp.MatchString(record[0]) {
+			// header row
+			continue
+		}
+		channel := ImportedChannel{ChannelID: strings.TrimSpace(record[0]), URL: strings.TrimSpace(record[1])}
+		if len(record) > 2 {
+			channel.Title = strings.TrimSpace(record[2])
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+func channelVideosURL(channelID string) string {
+	return "https://www.youtube.com/channel/" + channelID + "/videos"
+}
+
+func channelIDCachePath() string {
+	return settingsXML.Config + "channelids.json"
+}
+
+// configuredChannels holds the channel IDs of the PodcastDownload and
+// PodcastsNotifty entries already in the settings. IDs in the URLs and the
+// ones resolved by earlier imports are used first; the remaining URLs are
+// only read from the network when an imported channel is not among them.
+type configuredChannels struct {
+	ids        map[string]bool
+	unresolved []string
+	cache      map[string]string
+}
+
+func newConfiguredChannels() *configuredChannels {
+	configured := &configuredChannels{ids: map[string]bool{}, cache: map[string]string{}}
+	if content, err := os.ReadFile(channelIDCachePath()); err == nil {
+		json.Unmarshal(content, &configured.cache)
+	}
+
+	var urls []string
+	for _, podcast := range settingsXML.PodcastDownload {
+		urls = append(urls, podcast.YouTubeURL)
+	}
+	for _, notify := range settingsXML.PodcastsNotifty {
+		urls = append(urls, notify.YouTubeURL)
+	}
+	for _, channelURL := range urls {
+		if !strings.Contains(channelURL, "youtube.com/") || strings.Contains(channelURL, "list=") {
+			continue
+		}
+		if id := channelIDFromURL(channelURL); id != "" {
+			configured.ids[id] = true
+		} else if id, ok := configured.cache[channelURL]; ok {
+			configured.ids[id] = true
+		} else {
+			configured.unresolved = append(configured.unresolved, channelURL)
+		}
+	}
+	return configured
+}
+
+// Has reports whether channelID is configured, resolving the remaining
+// configured URLs on the first miss.
+func (c *configuredChannels) Has(channelID string) bool {
+	if c.ids[channelID] || len(c.unresolved) == 0 {
+		return c.ids[channelID]
+	}
+	for _, channelURL := range c.unresolved {
+		id, err := ResolveChannelID(channelURL)
+		if err != nil {
+			log.Println("Unable to resolve configured channel: " + err.Error())
+			continue
+		}
+		c.ids[id] = true
+		c.cache[channelURL] = id
+	}
+	c.unresolved = nil
+	if content, err := json.MarshalIndent(c.cache, "", "\t"); err == nil {
+		if err := WriteFileAtomic(channelIDCachePath(), content, 0666); err != nil {
+			log.Println("Unable to write " + channelIDCachePath() + ": " + err.Error())
+		}
+	}
+	return c.ids[channelID]
+}
+
+// Add marks an imported channel as configured.
+func (c *configuredChannels) Add(channelID string) {
+	c.ids[channelID] = true
+}
+
+// CreateDownloadArchive creates an empty yt-dlp download archive, keeping an
+// existing one.
+func CreateDownloadArchive(archive string) error {
+	archiveFile, err := os.OpenFile(archive, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return archiveFile.Close()
+}
+
+func uniqueFeedChannelID(title string, channelID string, used map[string]bool) string {
+	name := channelIDNameRegexp.ReplaceAllString(title, "")
+	if name == "" {
+		name = channelID
+	}
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = name + strconv.Itoa(i)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+// AppendSettingsEntries adds entries before the closing tag of the settings
+// file, leaving the rest of the file (and its comments) untouched.
+func AppendSettingsEntries(path string, element string, entries []interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	closeIdx := bytes.LastIndex(content, []byte("</"))
+	if closeIdx < 0 {
+		return errors.New(path + " has no closing settings tag")
+	}
+
+	var block bytes.Buffer
+	encoder := xml.NewEncoder(&block)
+	encoder.Indent("\t", "\t")
+	for _, entry := range entries {
+		if err := encoder.EncodeElement(entry, xml.StartElement{Name: xml.Name{Local: element}}); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	block.WriteString("\n")
+
+	updated := append([]byte{}, content[:closeIdx]...)
+	updated = append(bytes.TrimRight(updated, " \t"), block.Bytes()...)
+	updated = append(updated, content[closeIdx:]...)
+
+	if err := WriteBackup(path, content); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, updated, 0600)
+}
+
+// settingsEntrySpan finds the byte range of the index-th <element> directly
+// below the root of a settings file, widened to whole lines.
+func settingsEntrySpan(content []byte, element string, index int) (int, int, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	depth, found, start := 0, 0, -1
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 && token.Name.Local == element {
+				if found == index {
+					start = offset
+				}
+				found++
+			}
+		case xml.EndElement:
+			depth--
+			if depth == 1 && start >= 0 {
+				end := int(decoder.InputOffset())
+				for start > 0 && (content[start-1] == ' ' || content[start-1] == '\t') {
+					start--
+				}
+				for end < len(content) && (content[end] == ' ' || content[end] == '\t' || content[end] == '\r') {
+					end++
+				}
+				if end < len(content) && content[end] == '\n' {
+					end++
+				}
+				return start, end, nil
+			}
+		}
+	}
+	return 0, 0, errors.New("no " + element + " entry " + strconv.Itoa(index) + " in the settings file")
+}
+
+// ReplaceSettingsEntry rewrites the index-th <element> entry of the settings
+// file, or removes it when entry is nil, leaving the rest of the file (and
+// its comments) untouched.
+func ReplaceSettingsEntry(path string, element string, index int, entry interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	start, end, err := settingsEntrySpan(content, element, index)
+	if err != nil {
+		return err
+	}
+
+	var block bytes.Buffer
+	if entry != nil {
+		encoder := xml.NewEncoder(&block)
+		encoder.Indent("\t", "\t")
+		if err := encoder.EncodeElement(entry, xml.StartElement{Name: xml.Name{Local: element}}); err != nil {
+			return err
+		}
+		if err := encoder.Flush(); err != nil {
+			return err
+		}
+		block.WriteString("\n")
+	}
+
+	updated := append([]byte{}, content[:start]...)
+	updated = append(updated, block.Bytes()...)
+	updated = append(updated, content[end:]...)
+
+	if err := WriteBackup(path, content); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, updated, 0600)
+}
+
+// ImportSubscriptions reads an OPML file or a Takeout subscriptions.csv and
+// appends a PodcastsNotifty ("notify") or PodcastDownload ("download") entry
+// for every channel not already configured.
+func ImportSubscriptions(importPath string, mode string, pushoverAppToken string) error {
+	content, err := os.ReadFile(importPath)
+	if err != nil {
+		return err
+	}
+
+	var channels []ImportedChannel
+	if strings.EqualFold(filepath.Ext(importPath), ".csv") {
+		channels, err = ReadTakeoutChannels(content)
+	} else {
+		channels, err = ReadOPMLChannels(content)
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("Channels in " + importPath + ": " + strconv.Itoa(len(channels)))
+
+	configured := newConfiguredChannels()
+	usedChannelIDs := map[string]bool{}
+	for _, podcast := range settingsXML.PodcastDownload {
+		usedChannelIDs[podcast.ChannelID] = true
+	}
+	for _, rss := range settingsXML.RSSDownload {
+		usedChannelIDs[rss.ChannelID] = true
+	}
+
+	var entries []interface{}
+	var archives []string
+	for _, channel := range channels {
+		if channel.ChannelID == "" {
+			channel.ChannelID, err = ResolveChannelID(channel.URL)
+			if err != nil {
+				log.Println("SKIP " + channel.Title + ": " + err.Error())
+				continue
+			}
+		}
+		if configured.Has(channel.ChannelID) {
+			log.Println("SKIP " + channel.Title + ": already configured (" + channel.ChannelID + ")")
+			continue
+		}
+		configured.Add(channel.ChannelID)
+		if channel.Title == "" {
+			channel.Title = channel.ChannelID
+		}
+
+		if mode == "download" {
+			feedChannelID := uniqueFeedChannelID(channel.Title, channel.ChannelID, usedChannelIDs)
+			archive := settingsXML.Config + "youtube-dl-archive-" + feedChannelID + ".txt"
+			archives = append(archives, archive)
+
+			entries = append(entries, YouTubeDownload{
+				Name:             channel.Title,
+				ChannelID:        feedChannelID,
+				FileFormat:       "mp4",
+				DownloadArchive:  archive,
+				FileQuality:      "best",
+				YouTubeURL:       channelVideosURL(channel.ChannelID),
+				PushoverAppToken: pushoverAppToken,
+			})
+			log.Println("ADD PodcastDownload: " + channel.Title + " (" + feedChannelID + ")")
+		} else {
+			entries = append(entries, PodcastsNotifty{
+				Name:             channel.Title,
+				YouTubeURL:       channelVideosURL(channel.ChannelID),
+				PushoverAppToken: pushoverAppToken,
+			})
+			log.Println("ADD PodcastsNotifty: " + channel.Title + " (" + channel.ChannelID + ")")
+		}
+	}
+
+	if len(entries) == 0 {
+		log.Println("No new channels to import")
+		return nil
+	}
+
+	element := "PodcastsNotifty"
+	if mode == "download" {
+		element = "PodcastDownload"
+	}
+	if err := AppendSettingsEntries(settingsPath, element, entries); err != nil {
+		return err
+	}
+	// PodcastDownload entries are only run once their archive exists
+	for _, archive := range archives {
+		if err := CreateDownloadArchive(archive); err != nil {
+			return err
+		}
+	}
+	log.Println("Imported " + strconv.Itoa(len(entries)) + " channels into " + settingsPath)
+	return nil
+}
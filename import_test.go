@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSettings = `<settings>
+	<!-- downloads -->
+	<PodcastDownload>
+		<Name>One</Name>
+		<ChannelID>UC1</ChannelID>
+	</PodcastDownload>
+	<PodcastDownload>
+		<Name>Two</Name>
+		<ChannelID>UC2</ChannelID>
+	</PodcastDownload>
+	<RSSDownload>
+		<Name>One</Name>
+	</RSSDownload>
+</settings>
+`
+
+func TestReplaceSettingsEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.xml")
+	if err := os.WriteFile(path, []byte(testSettings), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := YouTubeDownload{Name: "Two", ChannelID: "UC2", FileFormat: "mp3", DownloadArchive: "/config/two.txt", FileQuality: "best", YouTubeURL: "https://www.youtube.com/@two"}
+	if err := ReplaceSettingsEntry(path, "PodcastDownload", 1, entry); err != nil {
+		t.Fatal(err)
+	}
+	want := `<settings>
+	<!-- downloads -->
+	<PodcastDownload>
+		<Name>One</Name>
+		<ChannelID>UC1</ChannelID>
+	</PodcastDownload>
+	<PodcastDownload>
+		<Name>Two</Name>
+		<ChannelID>UC2</ChannelID>
+		<FileFormat>mp3</FileFormat>
+		<DownloadArchive>/config/two.txt</DownloadArchive>
+		<FileQuality>best</FileQuality>
+		<YouTubeURL>https://www.youtube.com/@two</YouTubeURL>
+	</PodcastDownload>
+	<RSSDownload>
+		<Name>One</Name>
+	</RSSDownload>
+</settings>
+`
+	if content, _ := os.ReadFile(path); string(content) != want {
+		t.Errorf("after replace:\n%s\nwant:\n%s", content, want)
+	}
+	if backup, _ := os.ReadFile(path + ".bak"); string(backup) != testSettings {
+		t.Errorf("backup = %q, want the previous file", backup)
+	}
+
+	if err := ReplaceSettingsEntry(path, "PodcastDownload", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceSettingsEntry(path, "PodcastDownload", 1, nil); err == nil {
+		t.Error("removing a missing entry succeeded")
+	}
+	var got settings
+	content, _ := os.ReadFile(path)
+	if err := xml.Unmarshal(content, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.PodcastDownload) != 1 || got.PodcastDownload[0].Name != "Two" || len(got.RSSDownload) != 1 {
+		t.Errorf("after remove: %+v", got)
+	}
+}
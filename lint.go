@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ============= Apple Podcasts / Podcasting 2.0 Lint ======
+// =========================================================
+
+type LintIssue struct {
+	Severity string
+	Where    string
+	Message  string
+}
+
+type LintReport struct {
+	Feed   string
+	Issues []LintIssue
+}
+
+type lintURLAttr struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type lintCategory struct {
+	Text string `xml:"text,attr"`
+}
+
+type lintItem struct {
+	Title       string             `xml:"title"`
+	GUID        string             `xml:"guid"`
+	PubDate     string             `xml:"pubDate"`
+	Enclosures  []rssItemEnclosure `xml:"enclosure"`
+	ItunesImage []rssItemImage     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Explicit    string             `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	Duration    string             `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Chapters    []lintURLAttr      `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+	Transcripts []lintURLAttr      `xml:"https://podcastindex.org/namespace/1.0 transcript"`
+}
+
+type lintChannel struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	Description string         `xml:"description"`
+	Language    string         `xml:"language"`
+	ItunesImage []rssItemImage `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Explicit    string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	Categories  []lintCategory `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+	Items       []lintItem     `xml:"item"`
+}
+
+type lintDocument struct {
+	XMLName xml.Name    `xml:"rss"`
+	Version string      `xml:"version,attr"`
+	Channel lintChannel `xml:"channel"`
+}
+
+var lintPlaceholders = []string{"[CHANNEL_LINK]", "[PODCAST_TITLE]", "[PODCAST_IMAGE]", "[PODCAST_DESCRIPTION]", "[ITEM_CHAPTER_URL]"}
+
+var lintNamespaces = map[string]string{
+	"itunes":  itunesNamespace,
+	"podcast": podcastNamespace,
+	"atom":    atomNamespace,
+	"fh":      historyNamespace,
+}
+
+func (report *LintReport) add(severity string, where string, message string) {
+	report.Issues = append(report.Issues, LintIssue{Severity: severity, Where: where, Message: message})
+}
+
+func (report LintReport) Errors() int {
+	errorCount := 0
+	for _, issue := range report.Issues {
+		if issue.Severity == "error" {
+			errorCount++
+		}
+	}
+	return errorCount
+}
+
+func (report LintReport) Log() {
+	if len(report.Issues) == 0 {
+		log.Println("LINT OK: " + report.Feed)
+		return
+	}
+	for _, issue := range report.Issues {
+		log.Println("LINT " + strings.ToUpper(issue.Severity) + ": " + report.Feed + ": " + issue.Where + ": " + issue.Message)
+	}
+	log.Println("LINT: " + report.Feed + ": " + strconv.Itoa(report.Errors()) + " errors, " + strconv.Itoa(len(report.Issues)-report.Errors()) + " warnings")
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func lintArtwork(report *LintReport, where string, href string) {
+	if !isHTTPURL(href) {
+		report.add("error", where, "artwork URL is not an http(s) URL: \""+href+"\"")
+		return
+	}
+	artworkPath := strings.ToLower(href)
+	if i := strings.IndexAny(artworkPath, "?#"); i >= 0 {
+		artworkPath = artworkPath[:i]
+	}
+	switch filepath.Ext(artworkPath) {
+	case ".jpg", ".jpeg", ".png":
+	case ".webp":
+		report.add("error", where, "artwork is webp, Apple Podcasts only accepts JPEG or PNG: "+href)
+	default:
+		report.add("warning", where, "artwork URL has no .jpg or .png extension: "+href)
+	}
+}
+
+func lintExplicit(report *LintReport, where string, explicit string) {
+	switch strings.TrimSpace(explicit) {
+	case "true", "false":
+	default:
+		report.add("error", where, "itunes:explicit must be \"true\" or \"false\", not \""+explicit+"\"")
+	}
+}
+
+// LintFeedData checks one RSS document. guids collects item GUIDs across the
+// main feed and its archive pages so duplicates between documents are found.
+func LintFeedData(name string, data []byte, guids map[string]string) LintReport {
+	report := LintReport{Feed: name}
+	text := string(data)
+
+	for prefix, uri := range lintNamespaces {
+		if strings.Contains(text, "<"+prefix+":") && !strings.Contains(text, "xmlns:"+prefix+"=\""+uri+"\"") {
+			report.add("error", "rss", "the "+prefix+": prefix is used but not declared as "+uri)
+		}
+	}
+	for _, placeholder := range lintPlaceholders {
+		if strings.Contains(text, placeholder) {
+			report.add("error", "rss", "unreplaced placeholder "+placeholder)
+		}
+	}
+
+	var doc lintDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		report.add("error", "rss", "not well-formed XML: "+err.Error())
+		return report
+	}
+	if doc.Version != "2.0" {
+		report.add("error", "rss", "rss version must be 2.0")
+	}
+
+	// ------- Channel Tags ------
+	channel := doc.Channel
+	if strings.TrimSpace(channel.Title) == "" {
+		report.add("error", "channel", "missing <title>")
+	}
+	if strings.TrimSpace(channel.Description) == "" || channel.Description == "<nil>" {
+		report.add("error", "channel", "missing <description>")
+	}
+	if !isHTTPURL(channel.Link) {
+		report.add("warning", "channel", "<link> is not an http(s) URL: \""+channel.Link+"\"")
+	}
+	if strings.TrimSpace(channel.Language) == "" {
+		report.add("error", "channel", "missing <language>")
+	}
+	if len(channel.Categories) == 0 {
+		report.add("error", "channel", "missing <itunes:category>")
+	}
+	if len(channel.ItunesImage) == 0 {
+		report.add("error", "channel", "missing <itunes:image>")
+	} else {
+		lintArtwork(&report, "channel itunes:image", channel.ItunesImage[0].Href)
+	}
+	if channel.Explicit == "" {
+		report.add("error", "channel", "missing <itunes:explicit>")
+	} else {
+		lintExplicit(&report, "channel", channel.Explicit)
+	}
+
+	// -------- Item Tags --------
+	for i, item := range channel.Items {
+		where := "item " + strconv.Itoa(i+1)
+		if strings.TrimSpace(item.Title) == "" {
+			report.add("error", where, "missing <title>")
+		} else {
+			where += " (" + item.Title + ")"
+		}
+
+		guid := strings.TrimSpace(item.GUID)
+		if guid == "" {
+			report.add("error", where, "missing <guid>")
+		} else if first, ok := guids[guid]; ok {
+			report.add("error", where, "duplicate guid "+guid+" (also in "+first+")")
+		} else {
+			guids[guid] = name
+		}
+
+		if _, err := time.Parse(time.RFC1123Z, strings.TrimSpace(item.PubDate)); err != nil {
+			if _, err := time.Parse(time.RFC1123, strings.TrimSpace(item.PubDate)); err != nil {
+				report.add("error", where, "pubDate is not an RFC 2822 date: \""+item.PubDate+"\"")
+			}
+		}
+
+		if len(item.Enclosures) != 1 {
+			report.add("error", where, "must have exactly one <enclosure>, has "+strconv.Itoa(len(item.Enclosures)))
+		}
+		for _, enclosure := range item.Enclosures {
+			if !isHTTPURL(enclosure.URL) {
+				report.add("error", where, "enclosure url is not an http(s) URL: \""+enclosure.URL+"\"")
+			}
+			if !strings.HasPrefix(enclosure.Type, "audio/") && !strings.HasPrefix(enclosure.Type, "video/") && enclosure.Type != "application/pdf" {
+				report.add("error", where, "enclosure type is not an audio or video MIME type: \""+enclosure.Type+"\"")
+			} else if enclosure.Type == "video/mpeg" && strings.HasSuffix(strings.SplitN(enclosure.URL, "?", 2)[0], ".mp4") {
+				report.add("warning", where, "enclosure type for .mp4 should be video/mp4, not video/mpeg")
+			}
+			if length, err := strconv.ParseInt(enclosure.Length, 10, 64); err != nil || length <= 0 {
+				report.add("error", where, "enclosure length must be the file size in bytes, not \""+enclosure.Length+"\"")
+			}
+		}
+
+		if len(item.ItunesImage) > 0 {
+			lintArtwork(&report, where+" itunes:image", item.ItunesImage[0].Href)
+		}
+		if item.Explicit != "" {
+			lintExplicit(&report, where, item.Explicit)
+		}
+		if item.Duration != "" && durationSeconds(item.Duration) == 0 && item.Duration != "0" {
+			report.add("warning", where, "itunes:duration is not seconds or [hh:]mm:ss: \""+item.Duration+"\"")
+		}
+		for _, chapters := range item.Chapters {
+			if !isHTTPURL(chapters.URL) {
+				report.add("error", where, "podcast:chapters url is not an http(s) URL: \""+chapters.URL+"\"")
+			}
+		}
+		for _, transcript := range item.Transcripts {
+			if !isHTTPURL(transcript.URL) || transcript.Type == "" {
+				report.add("error", where, "podcast:transcript needs an http(s) url and a type")
+			}
+		}
+	}
+
+	return report
+}
+
+// LintFeedSet lints a channel's main feed and archive pages.
+func LintFeedSet(sRSSFolder string, pChannelID string) []LintReport {
+	var reports []LintReport
+	guids := map[string]string{}
+	paths := append([]string{sRSSFolder + RSSFileName(pChannelID)}, ListArchiveFiles(sRSSFolder, pChannelID)...)
+	for _, feedPath := range paths {
+		data, err := os.ReadFile(feedPath)
+		if err != nil {
+			report := LintReport{Feed: filepath.Base(feedPath)}
+			report.add("error", "file", err.Error())
+			reports = append(reports, report)
+			continue
+		}
+		reports = append(reports, LintFeedData(filepath.Base(feedPath), data, guids))
+	}
+	return reports
+}
+
+// FeedLintError is returned for a feed that was written but failed lint with
+// FailOnLint set. The feed is on disk, so callers finish their work first and
+// fail afterwards.
+type FeedLintError struct {
+	Feed   string
+	Errors int
+}
+
+func (e *FeedLintError) Error() string {
+	return e.Feed + " failed lint with " + strconv.Itoa(e.Errors) + " errors"
+}
+
+// IsFeedLintError reports whether err only says that a written feed failed
+// lint.
+func IsFeedLintError(err error) bool {
+	var lintErr *FeedLintError
+	return errors.As(err, &lintErr)
+}
+
+// CheckFeed is the post-write lint: it logs the report and, with FailOnLint
+// set, turns lint errors into a FeedLintError.
+func CheckFeed(sRSSFolder string, pChannelID string) error {
+	errorCount := 0
+	for _, report := range LintFeedSet(sRSSFolder, pChannelID) {
+		report.Log()
+		errorCount += report.Errors()
+	}
+	if errorCount > 0 && settingBool(settingsXML.FailOnLint) {
+		return &FeedLintError{Feed: RSSFileName(pChannelID), Errors: errorCount}
+	}
+	return nil
+}
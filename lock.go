@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// =========================================================
+// ================ Atomic Writes / File Locks =============
+// =========================================================
+
+var errLocked = errors.New("locked by another run")
+
+// WriteFileAtomic writes data to a temp file next to path, fsyncs it and
+// renames it over path, so readers only ever see the old or the new file.
+// An existing file keeps its mode and owner; a new one gets perm less the
+// umask.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmpName := filepath.Join(dir, "."+filepath.Base(path)+".tmp-"+NewToken()[:12])
+	// OpenFile applies the umask, unlike a Chmod afterwards
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+			tmp.Close()
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			// only root can give the file away, keep going without it
+			tmp.Chown(int(stat.Uid), int(stat.Gid))
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// make the rename itself durable
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
+
+// WriteBackup keeps content, the old version of path, as path.bak with the
+// same mode as path, since settings files hold tokens and passwords.
+func WriteBackup(path string, content []byte) error {
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := WriteFileAtomic(path+".bak", content, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path+".bak", perm)
+}
+
+// AppendLine appends one line to a download archive under an exclusive lock,
+// the same way yt-dlp writes it.
+func AppendLine(path string, line string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func lockPath(name string) string {
+	dir := settingsXML.Config
+	if dir == "" || !IsValid(dir) {
+		dir = os.TempDir() + "/"
+	}
+	return dir + name + ".lock"
+}
+
+// LockFile takes an exclusive flock on a lock file. With wait false it returns
+// errLocked instead of blocking when another process holds the lock. The lock
+// is released by Unlock or when the process exits.
+func LockFile(path string, wait bool) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func Unlock(file *os.File) {
+	if file == nil {
+		return
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	file.Close()
+}
+
+// LockFeed serialises everything that reads and rewrites one channel's feed
+// files (the cron run, repair-feed, header refresh).
+func LockFeed(pChannelID string) *os.File {
+	file, err := LockFile(lockPath("feed-"+pChannelID), true)
+	if err != nil {
+		fatalRun("Unable to lock feed " + pChannelID + ": " + err.Error())
+	}
+	return file
+}
+
+// LockRun takes the global run lock. RunLock "wait" blocks until the other
+// run has finished; anything else skips this run (returns false).
+func LockRun() (*os.File, bool) {
+	wait := settingsXML.RunLock == "wait"
+	if wait {
+		log.Println("Waiting for run lock: " + lockPath("DownloadYouTubeGo"))
+	}
+	file, err := LockFile(lockPath("DownloadYouTubeGo"), wait)
+	if err == errLocked {
+		return nil, false
+	}
+	if err != nil {
+		log.Fatal("Unable to take run lock: " + err.Error())
+	}
+	return file, true
+}
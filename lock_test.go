@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	oldMask := syscall.Umask(0022)
+	defer syscall.Umask(oldMask)
+	dir := t.TempDir()
+
+	newFile := filepath.Join(dir, "new.json")
+	if err := WriteFileAtomic(newFile, []byte("a"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(newFile); info.Mode().Perm() != 0644 {
+		t.Errorf("new file mode = %v, want umask applied (0644)", info.Mode().Perm())
+	}
+
+	secret := filepath.Join(dir, "secret.json")
+	if err := WriteFileAtomic(secret, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(secret); info.Mode().Perm() != 0600 {
+		t.Errorf("secret mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	existing := filepath.Join(dir, "settings.xml")
+	if err := os.WriteFile(existing, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(existing, 0640)
+	if err := WriteFileAtomic(existing, []byte("new"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(existing); info.Mode().Perm() != 0640 {
+		t.Errorf("existing file mode = %v, want 0640 kept", info.Mode().Perm())
+	}
+	if err := WriteBackup(existing, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(existing + ".bak"); info.Mode().Perm() != 0640 {
+		t.Errorf("backup mode = %v, want 0640", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =========================================================
+// ================== Prometheus Metrics ===================
+// =========================================================
+
+type metricFamily struct {
+	Name string
+	Type string
+	Help string
+}
+
+// metricFamilies are written in this order. Every series is labelled with the
+// feed's state key (the ChannelID, or notify:<Name>).
+var metricFamilies = []metricFamily{
+	{"dyg_runs_total", "counter", "Runs per feed."},
+	{"dyg_run_successes_total", "counter", "Runs per feed that finished without error."},
+	{"dyg_run_failures_total", "counter", "Failed runs per feed by failure class."},
+	{"dyg_episodes_downloaded_total", "counter", "Episodes added to the feed."},
+	{"dyg_downloaded_bytes_total", "counter", "Size of the media files added to the feed."},
+	{"dyg_notifications_sent_total", "counter", "Notifications sent by backend."},
+	{"dyg_notifications_failed_total", "counter", "Notifications that could not be sent by backend."},
+	{"dyg_retention_deleted_episodes_total", "counter", "Episodes deleted from MediaFolder by retention."},
+	{"dyg_ytdlp_duration_seconds", "histogram", "Duration of yt-dlp processes by step."},
+	{"dyg_disk_usage_bytes", "gauge", "Size of the feed's folder in MediaFolder."},
+}
+
+// ytdlpDurationBuckets are the histogram bounds in seconds.
+var ytdlpDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+var metricsMu sync.Mutex
+
+// metricValues holds every series by its exposition name ("name{labels}"),
+// metricSeries the series of each family in the order they were created.
+var metricValues = map[string]float64{}
+var metricSeries = map[string][]string{}
+
+func findMetricFamily(name string) (metricFamily, bool) {
+	for _, family := range metricFamilies {
+		if family.Name == name {
+			return family, true
+		}
+	}
+	return metricFamily{}, false
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	return strings.ReplaceAll(value, "\n", "\\n")
+}
+
+// metricLabels formats name, value pairs as {name="value",...}.
+func metricLabels(labels ...string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"=\""+escapeLabelValue(labels[i+1])+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	if value > 1e308 {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// updateMetric changes one series of a family, creating it at zero first.
+// Callers hold metricsMu.
+func updateMetric(family string, series string, update func(float64) float64) {
+	value, ok := metricValues[series]
+	if !ok {
+		metricSeries[family] = append(metricSeries[family], series)
+	}
+	metricValues[series] = update(value)
+}
+
+// MetricAdd adds value to a counter.
+func MetricAdd(name string, value float64, labels ...string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	updateMetric(name, name+metricLabels(labels...), func(old float64) float64 { return old + value })
+}
+
+// MetricSet sets a gauge.
+func MetricSet(name string, value float64, labels ...string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	updateMetric(name, name+metricLabels(labels...), func(float64) float64 { return value })
+}
+
+// MetricObserve adds one observation to a histogram with ytdlpDurationBuckets.
+func MetricObserve(name string, value float64, labels ...string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, bound := range ytdlpDurationBuckets {
+		inBucket := 0.0
+		if value <= bound {
+			inBucket = 1
+		}
+		updateMetric(name, name+"_bucket"+metricLabels(append(labels, "le", formatMetricValue(bound))...), func(old float64) float64 { return old + inBucket })
+	}
+	updateMetric(name, name+"_bucket"+metricLabels(append(labels, "le", "+Inf")...), func(old float64) float64 { return old + 1 })
+	updateMetric(name, name+"_sum"+metricLabels(labels...), func(old float64) float64 { return old + value })
+	updateMetric(name, name+"_count"+metricLabels(labels...), func(old float64) float64 { return old + 1 })
+}
+
+// FailureClass sorts a run error into a few classes for the failure counter.
+func FailureClass(err error) string {
+	message := err.Error()
+	var pathErr *os.PathError
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, errLocked):
+		return "locked"
+	case strings.HasPrefix(message, "yt-dlp channel JSON"):
+		return "channel_json"
+	case strings.HasPrefix(message, "yt-dlp"):
+		return "ytdlp"
+	case strings.Contains(message, "failed lint"):
+		return "lint"
+	case strings.HasPrefix(message, "Error reading JSON File") || strings.HasPrefix(message, "Error reading TikTok"):
+		return "json"
+	case errors.As(err, &urlErr):
+		return "network"
+	case errors.As(err, &pathErr) || strings.HasPrefix(message, "Error when opening file"):
+		return "filesystem"
+	}
+	return "other"
+}
+
+// RecordRunMetrics counts one run of a feed.
+func RecordRunMetrics(key string, runErr error) {
+	MetricAdd("dyg_runs_total", 1, "feed", key)
+	if runErr != nil {
+		MetricAdd("dyg_run_failures_total", 1, "feed", key, "class", FailureClass(runErr))
+	} else {
+		MetricAdd("dyg_run_successes_total", 1, "feed", key)
+	}
+}
+
+// RecordEpisode counts an episode added to a feed and its media file size.
+func RecordEpisode(key string, mediaFile string) {
+	MetricAdd("dyg_episodes_downloaded_total", 1, "feed", key)
+	if info, err := os.Stat(mediaFile); err == nil {
+		MetricAdd("dyg_downloaded_bytes_total", float64(info.Size()), "feed", key)
+	}
+}
+
+// RecordNotification counts a notification to one backend and logs why it
+// failed.
+func RecordNotification(key string, backend string, notifyErr error) {
+	if notifyErr != nil {
+		log.Println("Notification failed: " + key + " (" + backend + "): " + notifyErr.Error())
+		MetricAdd("dyg_notifications_failed_total", 1, "feed", key, "backend", backend)
+		return
+	}
+	MetricAdd("dyg_notifications_sent_total", 1, "feed", key, "backend", backend)
+}
+
+// ObserveYTDLP records how long a yt-dlp process started at started ran.
+func ObserveYTDLP(key string, step string, started time.Time) {
+	MetricObserve("dyg_ytdlp_duration_seconds", time.Since(started).Seconds(), "feed", key, "step", step)
+}
+
+// UpdateDiskUsageMetrics sets the disk usage gauge of every configured feed.
+func UpdateDiskUsageMetrics() {
+	for _, feed := range ConfiguredFeeds() {
+		MetricSet("dyg_disk_usage_bytes", float64(FolderSize(settingsXML.MediaFolder+feed.ChannelID)), "feed", feed.ChannelID)
+	}
+}
+
+// WriteMetrics writes all metrics in the Prometheus text format.
+func WriteMetrics(w io.Writer) error {
+	UpdateDiskUsageMetrics()
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	buf := bufio.NewWriter(w)
+	for _, family := range metricFamilies {
+		if len(metricSeries[family.Name]) == 0 {
+			continue
+		}
+		buf.WriteString("# HELP " + family.Name + " " + family.Help + "\n")
+		buf.WriteString("# TYPE " + family.Name + " " + family.Type + "\n")
+		for _, series := range metricSeries[family.Name] {
+			buf.WriteString(series + " " + formatMetricValue(metricValues[series]) + "\n")
+		}
+	}
+	return buf.Flush()
+}
+
+// LoadMetricsTextfile adds the counters and histograms of an earlier run's
+// textfile, so they keep counting up across one-shot cron runs.
+func LoadMetricsTextfile(path string) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	var family metricFamily
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			family, _ = findMetricFamily(fields[2])
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || (family.Type != "counter" && family.Type != "histogram") {
+			continue
+		}
+		split := strings.LastIndex(line, " ")
+		if split < 0 || !strings.HasPrefix(line, family.Name) {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[split+1:], 64)
+		if err != nil {
+			continue
+		}
+		updateMetric(family.Name, line[:split], func(old float64) float64 { return old + value })
+	}
+	return nil
+}
+
+// WriteMetricsTextfile writes the metrics for node_exporter's textfile
+// collector, which wants the file replaced in one step.
+func WriteMetricsTextfile(path string) error {
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// metricsTextfileLoaded is set once LoadRunMetrics has read the textfile;
+// only then may it be overwritten.
+var metricsTextfileLoaded bool
+
+// LoadRunMetrics continues the counters of the last run's MetricsTextfile.
+func LoadRunMetrics() {
+	if settingsXML.MetricsTextfile == "" {
+		return
+	}
+	if err := LoadMetricsTextfile(settingsXML.MetricsTextfile); err != nil {
+		log.Println("Metrics Textfile Error: " + err.Error())
+		return
+	}
+	metricsTextfileLoaded = true
+}
+
+// SaveRunMetrics writes the MetricsTextfile loaded by LoadRunMetrics.
+func SaveRunMetrics() {
+	if !metricsTextfileLoaded {
+		return
+	}
+	if err := WriteMetricsTextfile(settingsXML.MetricsTextfile); err != nil {
+		log.Println("Metrics Textfile Error: " + err.Error())
+	}
+}
+
+// fatalRun saves the metrics before log.Fatal ends a run half way, so the
+// feeds it did run are still counted.
+func fatalRun(v ...interface{}) {
+	SaveRunMetrics()
+	log.Fatal(v...)
+}
+
+// metricsHandler needs the APIKey: the series name every feed, private ones
+// too.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !keyAuthorized(r) {
+		requireKey(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := WriteMetrics(w); err != nil {
+		log.Println("Metrics Error: " + err.Error())
+	}
+}
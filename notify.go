@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ===================== Notifications =====================
+// =========================================================
+
+// NotifierConfig is one <Notifier> of a feed, or of the settings for feeds
+// without their own. Type is pushover, ntfy, gotify, discord, slack, telegram
+// or email; the other fields mean what that backend needs:
+//
+//	pushover  Token = app token, User = user key, Sound
+//	ntfy      URL = server (https://ntfy.sh), Topic, Token = access token
+//	gotify    URL = server, Token = app token
+//	discord   URL = webhook URL
+//	slack     URL = webhook URL
+//	telegram  Token = bot token, User = chat ID, URL = API (https://api.telegram.org)
+//	email     User = recipients, default the Email setting; sent with <SMTP>
+//
+// Priority is passed on to Pushover, ntfy and gotify; "silent" mutes Telegram.
+type NotifierConfig struct {
+	Type     string `xml:"Type"`
+	URL      string `xml:"URL,omitempty"`
+	Token    string `xml:"Token,omitempty"`
+	User     string `xml:"User,omitempty"`
+	Topic    string `xml:"Topic,omitempty"`
+	Priority string `xml:"Priority,omitempty"`
+	Sound    string `xml:"Sound,omitempty"`
+}
+
+// Notification is one message about a new episode or upload. Message is
+// plain text; every backend cuts it to its own limits. Feed and Episode are
+// the feed name and video title for the email digest.
+type Notification struct {
+	Feed     string
+	Episode  string
+	Title    string
+	Message  string
+	URL      string
+	URLTitle string
+	ImageURL string
+}
+
+// Notifier sends notifications to one backend.
+type Notifier interface {
+	Name() string
+	Notify(n Notification) error
+}
+
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// NewNotifier builds the backend of one NotifierConfig.
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	switch strings.ToLower(config.Type) {
+	case "pushover":
+		return pushoverNotifier{config}, nil
+	case "ntfy":
+		if config.Topic == "" {
+			return nil, errors.New("ntfy notifier needs a Topic")
+		}
+		return ntfyNotifier{config}, nil
+	case "gotify":
+		if config.URL == "" || config.Token == "" {
+			return nil, errors.New("gotify notifier needs URL and Token")
+		}
+		return gotifyNotifier{config}, nil
+	case "discord":
+		if config.URL == "" {
+			return nil, errors.New("discord notifier needs the webhook URL")
+		}
+		return discordNotifier{config}, nil
+	case "slack":
+		if config.URL == "" {
+			return nil, errors.New("slack notifier needs the webhook URL")
+		}
+		return slackNotifier{config}, nil
+	case "telegram":
+		if config.Token == "" || config.User == "" {
+			return nil, errors.New("telegram notifier needs Token and User (chat ID)")
+		}
+		return telegramNotifier{config}, nil
+	case "email":
+		if config.User == "" && settingsXML.Email == "" {
+			return nil, errors.New("email notifier needs User or the Email setting")
+		}
+		return emailNotifier{config}, nil
+	}
+	return nil, errors.New("unknown notifier type \"" + config.Type + "\"")
+}
+
+// FeedNotifierConfigs are the backends of a feed: its own <Notifier> entries,
+// or else the global ones, plus Pushover when the feed has a
+// PushoverAppToken and PushoverUserToken is set.
+func FeedNotifierConfigs(configs []NotifierConfig, pPushoverAppToken string, pPushoverUserToken string) []NotifierConfig {
+	if len(configs) == 0 {
+		configs = settingsXML.Notifiers
+	}
+	if pPushoverAppToken != "" && pPushoverUserToken != "" {
+		configs = append([]NotifierConfig{{Type: "pushover", Token: pPushoverAppToken, User: pPushoverUserToken}}, configs...)
+	}
+	return configs
+}
+
+// NewNotifiers builds the backends of configs, skipping broken entries.
+func NewNotifiers(configs []NotifierConfig) []Notifier {
+	var notifiers []Notifier
+	for _, config := range configs {
+		notifier, err := NewNotifier(config)
+		if err != nil {
+			log.Println("Notifier skipped: " + err.Error())
+			continue
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers
+}
+
+// DeliverNotification sends n to every backend and counts the results per
+// feed.
+func DeliverNotification(key string, notifiers []Notifier, n Notification) {
+	for _, notifier := range notifiers {
+		log.Println("Notify " + notifier.Name() + ": " + n.Title)
+		RecordNotification(key, notifier.Name(), notifier.Notify(n))
+	}
+}
+
+// redactURLError hides the path and query of the URL in a request error:
+// for the Telegram bot API and Discord and Slack webhooks it holds the
+// credentials, which must not end up in the log.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		redacted := "<redacted>"
+		if parsed, parseErr := url.Parse(urlErr.URL); parseErr == nil && parsed.Host != "" {
+			redacted = parsed.Scheme + "://" + parsed.Host + "/<redacted>"
+		}
+		urlErr.URL = redacted
+	}
+	return err
+}
+
+// postNotification sends a request body and fails on a non-2xx answer.
+func postNotification(rawURL string, contentType string, body []byte, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, redactURLError(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return nil, redactURLError(err)
+	}
+	defer resp.Body.Close()
+	answer, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return answer, errors.New(resp.Status + ": " + strings.TrimSpace(string(answer)))
+	}
+	return answer, nil
+}
+
+func postJSON(rawURL string, payload interface{}, headers map[string]string) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return postNotification(rawURL, "application/json", body, headers)
+}
+
+// ~~~~~~~~~~~~~~~~~~~ Pushover ~~~~~~~~~~~~~~~~~~~~
+
+type pushoverNotifier struct{ config NotifierConfig }
+
+func (p pushoverNotifier) Name() string { return "pushover" }
+
+func (p pushoverNotifier) Notify(n Notification) error {
+	return NotifyPushover(p.config.Token, p.config.User, n, p.config.Priority, p.config.Sound)
+}
+
+// pushoverAPI is the Pushover messages endpoint.
+var pushoverAPI = "https://api.pushover.net/1/messages.json"
+
+// pushoverMaxAttachment is Pushover's limit for an attached image.
+const pushoverMaxAttachment = 5242880
+
+// pushoverAttachment downloads an image into memory. Images Pushover would
+// refuse are left out instead of failing the message.
+func pushoverAttachment(imageURL string) ([]byte, string, error) {
+	resp, err := notifyClient.Get(imageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New(imageURL + ": " + resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, pushoverMaxAttachment+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) > pushoverMaxAttachment {
+		return nil, "", errors.New(imageURL + ": larger than Pushover's " + strconv.Itoa(pushoverMaxAttachment) + " bytes")
+	}
+	contentType := http.DetectContentType(content)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", errors.New(imageURL + ": not an image (" + contentType + ")")
+	}
+	return content, contentType, nil
+}
+
+// NotifyPushover sends n as one Pushover HTML message with the image at
+// n.ImageURL attached.
+func NotifyPushover(AppToken string, UserToken string, n Notification, Priority string, Sound string) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"token", AppToken},
+		{"user", UserToken},
+		{"title", limitText(n.Title, 250)},
+		{"message", limitHTML(n.Message, 1024)},
+		{"html", "1"},
+	}
+	if n.URL != "" && len(n.URL) <= 512 {
+		fields = append(fields, [2]string{"url", n.URL})
+		if n.URLTitle != "" {
+			fields = append(fields, [2]string{"url_title", limitText(n.URLTitle, 100)})
+		}
+	}
+	if Priority != "" {
+		fields = append(fields, [2]string{"priority", Priority})
+	}
+	if Sound != "" {
+		fields = append(fields, [2]string{"sound", Sound})
+	}
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+
+	if n.ImageURL != "" {
+		content, contentType, err := pushoverAttachment(n.ImageURL)
+		if err != nil {
+			log.Println("Pushover attachment skipped: " + err.Error())
+		} else {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", `form-data; name="attachment"; filename="thumbnail"`)
+			header.Set("Content-Type", contentType)
+			part, err := form.CreatePart(header)
+			if err != nil {
+				return err
+			}
+			part.Write(content)
+		}
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	answer, err := postNotification(pushoverAPI, form.FormDataContentType(), body.Bytes(), nil)
+	var result struct {
+		Status  int      `json:"status"`
+		Request string   `json:"request"`
+		Errors  []string `json:"errors"`
+	}
+	if jsonErr := json.Unmarshal(answer, &result); jsonErr == nil && result.Status != 1 {
+		return errors.New("pushover: " + strings.Join(result.Errors, ", ") + " (request " + result.Request + ")")
+	}
+	if err != nil {
+		return err
+	}
+	if result.Status != 1 {
+		return errors.New("pushover: unexpected answer: " + strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
+
+// ~~~~~~~~~~~~~~~~~~~~~ ntfy ~~~~~~~~~~~~~~~~~~~~~~
+
+type ntfyNotifier struct{ config NotifierConfig }
+
+func (p ntfyNotifier) Name() string { return "ntfy" }
+
+func (p ntfyNotifier) Notify(n Notification) error {
+	server := p.config.URL
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	// headers are ASCII, ntfy decodes RFC 2047 words
+	headers := map[string]string{"Title": mime.QEncoding.Encode("utf-8", limitText(n.Title, 250))}
+	if n.URL != "" {
+		headers["Click"] = n.URL
+	}
+	if n.ImageURL != "" {
+		headers["Attach"] = n.ImageURL
+	}
+	if p.config.Priority != "" {
+		headers["Priority"] = p.config.Priority
+	}
+	if p.config.Token != "" {
+		headers["Authorization"] = "Bearer " + p.config.Token
+	}
+	_, err := postNotification(strings.TrimSuffix(server, "/")+"/"+p.config.Topic, "text/plain; charset=utf-8", []byte(limitText(n.Message, 4000)), headers)
+	return err
+}
+
+// ~~~~~~~~~~~~~~~~~~~~ Gotify ~~~~~~~~~~~~~~~~~~~~~
+
+type gotifyNotifier struct{ config NotifierConfig }
+
+func (p gotifyNotifier) Name() string { return "gotify" }
+
+func (p gotifyNotifier) Notify(n Notification) error {
+	payload := map[string]interface{}{
+		"title":    n.Title,
+		"message":  n.Message,
+		"priority": settingInt(p.config.Priority, 5),
+	}
+	extras := map[string]interface{}{}
+	if n.URL != "" {
+		extras["client::notification"] = map[string]interface{}{"click": map[string]string{"url": n.URL}}
+	}
+	if n.ImageURL != "" {
+		notification, _ := extras["client::notification"].(map[string]interface{})
+		if notification == nil {
+			notification = map[string]interface{}{}
+		}
+		notification["bigImageUrl"] = n.ImageURL
+		extras["client::notification"] = notification
+	}
+	if len(extras) > 0 {
+		payload["extras"] = extras
+	}
+	_, err := postJSON(strings.TrimSuffix(p.config.URL, "/")+"/message", payload, map[string]string{"X-Gotify-Key": p.config.Token})
+	return err
+}
+
+// ~~~~~~~~~~~~~~~~~~~ Discord ~~~~~~~~~~~~~~~~~~~~~
+
+type discordNotifier struct{ config NotifierConfig }
+
+func (p discordNotifier) Name() string { return "discord" }
+
+func (p discordNotifier) Notify(n Notification) error {
+	embed := map[string]interface{}{
+		"title":       limitText(n.Title, 256),
+		"description": limitText(n.Message, 4096),
+	}
+	if n.URL != "" {
+		embed["url"] = n.URL
+	}
+	if n.ImageURL != "" {
+		embed["image"] = map[string]string{"url": n.ImageURL}
+	}
+	_, err := postJSON(p.config.URL, map[string]interface{}{"embeds": []interface{}{embed}}, nil)
+	return err
+}
+
+// ~~~~~~~~~~~~~~~~~~~~ Slack ~~~~~~~~~~~~~~~~~~~~~~
+
+type slackNotifier struct{ config NotifierConfig }
+
+func (p slackNotifier) Name() string { return "slack" }
+
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func (p slackNotifier) Notify(n Notification) error {
+	title := "*" + slackEscape(limitText(n.Title, 250)) + "*"
+	if n.URL != "" {
+		title = "*<" + n.URL + "|" + slackEscape(limitText(n.Title, 250)) + ">*"
+	}
+	_, err := postJSON(p.config.URL, map[string]string{"text": title + "\n" + slackEscape(limitText(n.Message, 3000))}, nil)
+	return err
+}
+
+// ~~~~~~~~~~~~~~~~~~~ Telegram ~~~~~~~~~~~~~~~~~~~~
+
+type telegramNotifier struct{ config NotifierConfig }
+
+func (p telegramNotifier) Name() string { return "telegram" }
+
+func (p telegramNotifier) Notify(n Notification) error {
+	api := p.config.URL
+	if api == "" {
+		api = "https://api.telegram.org"
+	}
+	// the link stays whole, the message is cut to fit text or caption
+	text := func(limit int) string {
+		suffix := ""
+		if n.URL != "" {
+			suffix = "\n\n" + n.URL
+		}
+		return limitText(n.Title+"\n\n"+n.Message, limit-len([]rune(suffix))) + suffix
+	}
+
+	method := "sendMessage"
+	payload := map[string]interface{}{"chat_id": p.config.User, "text": text(4096)}
+	if n.ImageURL != "" {
+		method = "sendPhoto"
+		payload = map[string]interface{}{"chat_id": p.config.User, "photo": n.ImageURL, "caption": text(1024)}
+	}
+	if p.config.Priority == "silent" {
+		payload["disable_notification"] = true
+	}
+
+	answer, err := postJSON(strings.TrimSuffix(api, "/")+"/bot"+p.config.Token+"/"+method, payload, nil)
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		ErrorCode   int    `json:"error_code"`
+	}
+	if jsonErr := json.Unmarshal(answer, &result); jsonErr == nil && !result.OK {
+		return errors.New("telegram " + strconv.Itoa(result.ErrorCode) + ": " + result.Description)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// =========================================================
+// ================ Batched Notifications ==================
+// =========================================================
+
+type pendingNotification struct {
+	Key          string
+	Configs      []NotifierConfig
+	Notification Notification
+}
+
+// NotificationBatch collects the notifications of one feed run, or of the
+// whole run with NotifyBatchScope run.
+type NotificationBatch struct {
+	mu        sync.Mutex
+	Key       string
+	Name      string
+	Threshold int
+	items     []pendingNotification
+}
+
+// runBatch is set while a cron run with NotifyBatchScope run is going on.
+var runBatch *NotificationBatch
+
+// NotifyBatchThreshold is a feed's NotifyBatch, or else the global one: the
+// number of new episodes from which a run sends one grouped notification
+// instead of one per episode. 0 or empty sends each. NotifyBatchScope run
+// groups the batched feeds of a cron run into one notification per backend.
+// Batches last one run; there is no time window across runs.
+func NotifyBatchThreshold(pNotifyBatch string) int {
+	if strings.TrimSpace(pNotifyBatch) != "" {
+		return settingInt(pNotifyBatch, 0)
+	}
+	return settingInt(settingsXML.NotifyBatch, 0)
+}
+
+// NewNotificationBatch starts collecting the notifications of a feed. key is
+// the feed's state key, name its Name.
+func NewNotificationBatch(key string, name string, pNotifyBatch string) *NotificationBatch {
+	return &NotificationBatch{Key: key, Name: name, Threshold: NotifyBatchThreshold(pNotifyBatch)}
+}
+
+// StartRunBatch begins the run-wide batch when NotifyBatchScope is run.
+func StartRunBatch() {
+	if strings.ToLower(strings.TrimSpace(settingsXML.NotifyBatchScope)) == "run" && settingInt(settingsXML.NotifyBatch, 0) > 0 {
+		runBatch = &NotificationBatch{Key: "run", Name: "all feeds", Threshold: settingInt(settingsXML.NotifyBatch, 0)}
+	}
+}
+
+// SendRunBatch sends and ends the run-wide batch.
+func SendRunBatch() {
+	batch := runBatch
+	runBatch = nil
+	if batch != nil {
+		batch.Send()
+	}
+}
+
+// Add queues a notification until Send.
+func (b *NotificationBatch) Add(key string, configs []NotifierConfig, n Notification) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items = append(b.items, pendingNotification{Key: key, Configs: configs, Notification: n})
+}
+
+// Send notifies about everything collected: one by one below the threshold,
+// else grouped. A feed batch hands its notifications to the run batch when
+// there is one.
+func (b *NotificationBatch) Send() {
+	b.mu.Lock()
+	items := b.items
+	b.items = nil
+	b.mu.Unlock()
+	if len(items) == 0 {
+		return
+	}
+
+	if run := runBatch; run != nil && run != b && b.Threshold > 0 {
+		for _, item := range items {
+			run.Add(item.Key, item.Configs, item.Notification)
+		}
+		return
+	}
+	// each backend gets only the notifications of its own feeds, grouped
+	// once there are Threshold of them
+	var configs []NotifierConfig
+	for _, item := range items {
+		for _, config := range item.Configs {
+			if !containsNotifierConfig(configs, config) {
+				configs = append(configs, config)
+			}
+		}
+		QueueDigest(item.Key, item.Notification)
+	}
+	for _, config := range configs {
+		var own []pendingNotification
+		for _, item := range items {
+			if containsNotifierConfig(item.Configs, config) {
+				own = append(own, item)
+			}
+		}
+		notifiers := NewNotifiers([]NotifierConfig{config})
+		if b.Threshold <= 0 || len(own) < b.Threshold {
+			for _, item := range own {
+				DeliverNotification(item.Key, notifiers, item.Notification)
+			}
+			continue
+		}
+		log.Println("Notify batch of " + strconv.Itoa(len(own)) + ": " + b.Name)
+		DeliverNotification(b.Key, notifiers, b.groupNotification(own))
+	}
+}
+
+func containsNotifierConfig(configs []NotifierConfig, config NotifierConfig) bool {
+	for _, c := range configs {
+		if c == config {
+			return true
+		}
+	}
+	return false
+}
+
+// groupNotification lists the collected episodes, with their feed in a
+// run-wide batch.
+func (b *NotificationBatch) groupNotification(items []pendingNotification) Notification {
+	var lines []string
+	for _, item := range items {
+		line := "• " + item.Notification.Episode
+		if b.Key == "run" {
+			line = "• " + item.Notification.Feed + ": " + item.Notification.Episode
+		}
+		lines = append(lines, line)
+	}
+	group := Notification{
+		Feed:     b.Name,
+		Title:    strconv.Itoa(len(items)) + " new episodes (" + b.Name + ")",
+		Message:  strings.Join(lines, "\n"),
+		ImageURL: items[0].Notification.ImageURL,
+	}
+	if strings.HasPrefix(b.Key, NotifyStateKey("")) {
+		group.Title = strconv.Itoa(len(items)) + " new uploads (" + b.Name + ")"
+	}
+	return group
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunBatchGroupsPerBackend(t *testing.T) {
+	oldSettings := settingsXML
+	defer func() { settingsXML, runBatch = oldSettings, nil }()
+	settingsXML.NotifyBatch = "2"
+	settingsXML.NotifyBatchScope = "run"
+
+	server, requests := fakeBackend(t, http.StatusOK, `{}`)
+	feedA := NotifierConfig{Type: "ntfy", URL: server.URL, Topic: "a"}
+	feedB := NotifierConfig{Type: "ntfy", URL: server.URL, Topic: "b"}
+	shared := NotifierConfig{Type: "ntfy", URL: server.URL, Topic: "shared"}
+
+	StartRunBatch()
+	batchA := NewNotificationBatch("UCA", "Feed A", "")
+	batchA.Add("UCA", []NotifierConfig{feedA, shared}, Notification{Feed: "Feed A", Episode: "A1", Title: "A1"})
+	batchA.Add("UCA", []NotifierConfig{feedA, shared}, Notification{Feed: "Feed A", Episode: "A2", Title: "A2"})
+	batchA.Send()
+	batchB := NewNotificationBatch("UCB", "Feed B", "")
+	batchB.Add("UCB", []NotifierConfig{feedB, shared}, Notification{Feed: "Feed B", Episode: "B1", Title: "B1", Message: "b1"})
+	batchB.Send()
+	if len(requests) != 0 {
+		t.Fatal("feed batches sent before the run batch")
+	}
+	SendRunBatch()
+
+	var got []string
+	for len(requests) > 0 {
+		req := <-requests
+		got = append(got, req.Path+" "+strings.ReplaceAll(req.Body, "\n", " | "))
+	}
+	sort.Strings(got)
+	want := []string{
+		"/a • Feed A: A1 | • Feed A: A2",
+		"/b b1",
+		"/shared • Feed A: A1 | • Feed A: A2 | • Feed B: B1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sent:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFeedBatchThreshold(t *testing.T) {
+	oldSettings := settingsXML
+	defer func() { settingsXML = oldSettings }()
+	settingsXML.NotifyBatch = ""
+
+	server, requests := fakeBackend(t, http.StatusOK, `{}`)
+	config := []NotifierConfig{{Type: "ntfy", URL: server.URL, Topic: "feed"}}
+
+	single := NewNotificationBatch("UC1", "Feed", "")
+	single.Add("UC1", config, Notification{Title: "One", Message: "one"})
+	single.Add("UC1", config, Notification{Title: "Two", Message: "two"})
+	single.Send()
+	if len(requests) != 2 {
+		t.Fatalf("without NotifyBatch %d requests, want one per episode", len(requests))
+	}
+	<-requests
+	<-requests
+
+	grouped := NewNotificationBatch("UC1", "Feed", "2")
+	grouped.Add("UC1", config, Notification{Episode: "One"})
+	grouped.Add("UC1", config, Notification{Episode: "Two"})
+	grouped.Send()
+	if len(requests) != 1 {
+		t.Fatalf("with NotifyBatch 2 %d requests, want one", len(requests))
+	}
+	if req := <-requests; req.Header.Get("Title") != "2 new episodes (Feed)" || req.Body != "• One\n• Two" {
+		t.Errorf("group %q: %q", req.Header.Get("Title"), req.Body)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ================ Notification Templates =================
+// =========================================================
+
+// NotifyTemplates are the per-feed NotifyTitle and NotifyBody: Go templates
+// executed with NotificationData, e.g.
+//
+//	<NotifyTitle>{{.Channel}}: {{.Title}}</NotifyTitle>
+//	<NotifyBody>{{.Duration}} - {{.Uploaded.Format "2 Jan 2006"}}&#10;{{truncate 300 .Description}}</NotifyBody>
+//
+// The body is plain text; backends that show HTML get it escaped.
+type NotifyTemplates struct {
+	NotifyTitle string `xml:"NotifyTitle,omitempty"`
+	NotifyBody  string `xml:"NotifyBody,omitempty"`
+}
+
+// NotificationData is the episode a notification is about. Kind is download
+// for PodcastDownload and RSSDownload feeds, upload for PodcastsNotifty.
+type NotificationData struct {
+	Kind        string
+	Feed        string
+	ID          string
+	Title       string
+	Description string
+	URL         string
+	Thumbnail   string
+	Duration    string
+	Channel     string
+	ChannelURL  string
+	Uploaded    time.Time
+	Info        map[string]interface{}
+}
+
+var defaultNotifyTemplates = map[string]NotifyTemplates{
+	"download": {
+		NotifyTitle: "RSS Podcast Downloaded ({{.Feed}})",
+		NotifyBody:  "{{.Title}}\n\n--------------------------------------------\n\n{{.Description}}",
+	},
+	"upload": {
+		NotifyTitle: "RSS YouTube Video Uploaded ({{.Feed}})",
+		NotifyBody:  "{{.Title}}\n\n{{.URL}}\n\n--------------------------------------------\n\n{{.Description}}",
+	},
+}
+
+// NewNotificationData collects the episode metadata of a yt-dlp info.json.
+func NewNotificationData(kind string, pName string, jsonpayload JsonData, mapresult map[string]interface{}) NotificationData {
+	channel := ""
+	for _, key := range []string{"channel", "uploader"} {
+		if v, ok := mapresult[key]; ok && v != nil {
+			channel = fmt.Sprint(v)
+			break
+		}
+	}
+	return NotificationData{
+		Kind:        kind,
+		Feed:        pName,
+		ID:          jsonpayload.id,
+		Title:       jsonpayload.title,
+		Description: jsonpayload.description,
+		URL:         jsonpayload.webpage_url,
+		Thumbnail:   jsonpayload.thumbnail,
+		Duration:    jsonpayload.duration_string,
+		Channel:     channel,
+		ChannelURL:  jsonpayload.channel_url,
+		Uploaded:    uploadTime(jsonpayload),
+		Info:        mapresult,
+	}
+}
+
+// renderNotifyTemplate executes one template, falling back to the default
+// when the feed's template is broken.
+func renderNotifyTemplate(name string, text string, def string, data NotificationData) string {
+	if text != "" {
+		out, err := executeTemplate(name, text, data)
+		if err == nil {
+			return strings.TrimSpace(out)
+		}
+		log.Println("Notification template " + name + " failed, using the default: " + err.Error())
+	}
+	out, _ := executeTemplate(name, def, data)
+	return strings.TrimSpace(out)
+}
+
+// RenderNotification builds the notification of an episode from the feed's
+// templates, or the built-in ones.
+func RenderNotification(data NotificationData, pTemplates NotifyTemplates) Notification {
+	def := defaultNotifyTemplates[data.Kind]
+	return Notification{
+		Feed:     data.Feed,
+		Episode:  data.Title,
+		Title:    renderNotifyTemplate("NotifyTitle", pTemplates.NotifyTitle, def.NotifyTitle, data),
+		Message:  renderNotifyTemplate("NotifyBody", pTemplates.NotifyBody, def.NotifyBody, data),
+		URL:      data.URL,
+		ImageURL: data.Thumbnail,
+	}
+}
+
+// ~~~~~~~~~~~~~~~~ Backend Limits ~~~~~~~~~~~~~~~~~
+
+// limitText cuts s to at most n characters, ending in "…" when cut.
+func limitText(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n < 1 {
+		return ""
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// limitHTML escapes plain text for a backend that shows HTML, with line
+// breaks as <br>, and cuts it so the escaped text has at most n characters.
+func limitHTML(s string, n int) string {
+	var out strings.Builder
+	length := 0
+	runes := []rune(s)
+	for i, r := range runes {
+		escaped := html.EscapeString(string(r))
+		if r == '\n' {
+			escaped = "<br>"
+		}
+		// keep room for the ellipsis unless this is the last character
+		room := n - 1
+		if i == len(runes)-1 {
+			room = n
+		}
+		if length+len([]rune(escaped)) > room {
+			out.WriteString("…")
+			return out.String()
+		}
+		out.WriteString(escaped)
+		length += len([]rune(escaped))
+	}
+	return out.String()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// capturedRequest is what a fake backend received.
+type capturedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+	Form   map[string]string
+}
+
+// fakeBackend answers every request with status and answer and hands the
+// request to the test.
+func fakeBackend(t *testing.T, status int, answer string) (*httptest.Server, <-chan capturedRequest) {
+	requests := make(chan capturedRequest, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured := capturedRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header, Form: map[string]string{}}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+			}
+			for key, values := range r.MultipartForm.Value {
+				captured.Form[key] = values[0]
+			}
+			for key := range r.MultipartForm.File {
+				captured.Form[key] = "<file>"
+			}
+		} else {
+			body, _ := io.ReadAll(r.Body)
+			captured.Body = string(body)
+		}
+		requests <- captured
+		w.WriteHeader(status)
+		io.WriteString(w, answer)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func jsonBody(t *testing.T, body string) map[string]interface{} {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatalf("body %q: %v", body, err)
+	}
+	return decoded
+}
+
+var testNotification = Notification{
+	Title:    "New <episode>",
+	Message:  "Line one\nLine & two",
+	URL:      "https://www.youtube.com/watch?v=abc",
+	URLTitle: "Watch",
+}
+
+func TestNotifyPushover(t *testing.T) {
+	server, requests := fakeBackend(t, http.StatusOK, `{"status":1,"request":"r1"}`)
+	oldAPI := pushoverAPI
+	pushoverAPI = server.URL + "/1/messages.json"
+	defer func() { pushoverAPI = oldAPI }()
+
+	image := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("\x89PNG\r\n\x1a\n0000"))
+	}))
+	defer image.Close()
+	n := testNotification
+	n.ImageURL = image.URL + "/thumb.png"
+
+	notifier, _ := NewNotifier(NotifierConfig{Type: "pushover", Token: "app", User: "user", Sound: "bike"})
+	if err := notifier.Notify(n); err != nil {
+		t.Fatal(err)
+	}
+	form := (<-requests).Form
+	want := map[string]string{"token": "app", "user": "user", "title": "New <episode>", "message": "Line one<br>Line &amp; two",
+		"html": "1", "url": n.URL, "url_title": "Watch", "sound": "bike", "attachment": "<file>"}
+	for key, value := range want {
+		if form[key] != value {
+			t.Errorf("%s = %q, want %q", key, form[key], value)
+		}
+	}
+}
+
+func TestNotifyPushoverErrors(t *testing.T) {
+	server, _ := fakeBackend(t, http.StatusBadRequest, `{"status":0,"request":"r2","errors":["application token is invalid"]}`)
+	oldAPI := pushoverAPI
+	pushoverAPI = server.URL
+	defer func() { pushoverAPI = oldAPI }()
+
+	err := NotifyPushover("bad", "user", testNotification, "", "")
+	if err == nil || !strings.Contains(err.Error(), "application token is invalid") {
+		t.Errorf("error = %v, want Pushover's reason", err)
+	}
+}
+
+func TestNotifyNtfy(t *testing.T) {
+	server, requests := fakeBackend(t, http.StatusOK, `{}`)
+	notifier, _ := NewNotifier(NotifierConfig{Type: "ntfy", URL: server.URL + "/", Topic: "podcasts", Token: "tk", Priority: "high"})
+	n := testNotification
+	n.Title = "Neue Folge: Käse"
+	n.ImageURL = "https://i.ytimg.com/vi/abc/maxresdefault.jpg"
+	if err := notifier.Notify(n); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.Path != "/podcasts" || req.Body != n.Message {
+		t.Errorf("request %s %q", req.Path, req.Body)
+	}
+	if req.Header.Get("Title") != "=?utf-8?q?Neue_Folge:_K=C3=A4se?=" {
+		t.Errorf("Title header = %q, want it Q-encoded", req.Header.Get("Title"))
+	}
+	headers := map[string]string{"Click": n.URL, "Attach": n.ImageURL, "Priority": "high", "Authorization": "Bearer tk"}
+	for key, value := range headers {
+		if req.Header.Get(key) != value {
+			t.Errorf("%s header = %q, want %q", key, req.Header.Get(key), value)
+		}
+	}
+}
+
+func TestNotifyGotify(t *testing.T) {
+	server, requests := fakeBackend(t, http.StatusOK, `{"id":1}`)
+	notifier, _ := NewNotifier(NotifierConfig{Type: "gotify", URL: server.URL, Token: "gk"})
+	n := testNotification
+	n.ImageURL = "https://example.com/a.jpg"
+	if err := notifier.Notify(n); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.Path != "/message" || req.Header.Get("X-Gotify-Key") != "gk" {
+		t.Errorf("request %s, key %q", req.Path, req.Header.Get("X-Gotify-Key"))
+	}
+	body := jsonBody(t, req.Body)
+	if body["title"] != n.Title || body["message"] != n.Message || body["priority"] != 5.0 {
+		t.Errorf("body = %v", body)
+	}
+	notification := body["extras"].(map[string]interface{})["client::notification"].(map[string]interface{})
+	if notification["bigImageUrl"] != n.ImageURL || notification["click"].(map[string]interface{})["url"] != n.URL {
+		t.Errorf("extras = %v", notification)
+	}
+}
+
+func TestNotifyDiscordAndSlack(t *testing.T) {
+	server, requests := fakeBackend(t, http.StatusNoContent, "")
+
+	discord, _ := NewNotifier(NotifierConfig{Type: "discord", URL: server.URL + "/api/webhooks/1/secret"})
+	if err := discord.Notify(testNotification); err != nil {
+		t.Fatal(err)
+	}
+	embed := jsonBody(t, (<-requests).Body)["embeds"].([]interface{})[0].(map[string]interface{})
+	if embed["title"] != testNotification.Title || embed["description"] != testNotification.Message || embed["url"] != testNotification.URL {
+		t.Errorf("discord embed = %v", embed)
+	}
+
+	slack, _ := NewNotifier(NotifierConfig{Type: "slack", URL: server.URL + "/services/T/B/secret"})
+	if err := slack.Notify(testNotification); err != nil {
+		t.Fatal(err)
+	}
+	want := "*<" + testNotification.URL + "|New &lt;episode&gt;>*\nLine one\nLine &amp; two"
+	if text := jsonBody(t, (<-requests).Body)["text"]; text != want {
+		t.Errorf("slack text = %q, want %q", text, want)
+	}
+}
+
+func TestNotifyTelegram(t *testing.T) {
+	server, requests := fakeBackend(t, http.StatusOK, `{"ok":true}`)
+	notifier, _ := NewNotifier(NotifierConfig{Type: "telegram", URL: server.URL, Token: "123:secret", User: "42", Priority: "silent"})
+
+	if err := notifier.Notify(testNotification); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	body := jsonBody(t, req.Body)
+	if req.Path != "/bot123:secret/sendMessage" || body["chat_id"] != "42" || body["disable_notification"] != true {
+		t.Errorf("request %s %v", req.Path, body)
+	}
+	if text := body["text"].(string); !strings.HasPrefix(text, testNotification.Title) || !strings.HasSuffix(text, "\n\n"+testNotification.URL) {
+		t.Errorf("text = %q", text)
+	}
+
+	n := testNotification
+	n.ImageURL = "https://example.com/a.jpg"
+	n.Message = strings.Repeat("x", 2000)
+	if err := notifier.Notify(n); err != nil {
+		t.Fatal(err)
+	}
+	req = <-requests
+	body = jsonBody(t, req.Body)
+	caption := body["caption"].(string)
+	if req.Path != "/bot123:secret/sendPhoto" || body["photo"] != n.ImageURL || len([]rune(caption)) > 1024 || !strings.HasSuffix(caption, n.URL) {
+		t.Errorf("photo request %s, caption of %d runes", req.Path, len([]rune(caption)))
+	}
+}
+
+func TestNotifyTelegramErrorsHideToken(t *testing.T) {
+	server, _ := fakeBackend(t, http.StatusUnauthorized, `{"ok":false,"error_code":401,"description":"Unauthorized"}`)
+	notifier, _ := NewNotifier(NotifierConfig{Type: "telegram", URL: server.URL, Token: "123:secret", User: "42"})
+	if err := notifier.Notify(testNotification); err == nil || err.Error() != "telegram 401: Unauthorized" {
+		t.Errorf("error = %v", err)
+	}
+
+	// a transport error names the request URL
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	notifier, _ = NewNotifier(NotifierConfig{Type: "telegram", URL: closed.URL, Token: "123:secret", User: "42"})
+	err := notifier.Notify(testNotification)
+	if err == nil {
+		t.Fatal("no error from a closed server")
+	}
+	if strings.Contains(err.Error(), "secret") || !strings.Contains(err.Error(), "<redacted>") {
+		t.Errorf("error = %q, want the bot token redacted", err.Error())
+	}
+	if FailureClass(err) != "network" {
+		t.Errorf("redacted error classed %q, want network", FailureClass(err))
+	}
+}
+
+func TestNewNotifierChecksConfig(t *testing.T) {
+	for _, config := range []NotifierConfig{
+		{Type: "ntfy"},
+		{Type: "gotify", URL: "https://gotify.example.com"},
+		{Type: "discord"},
+		{Type: "slack"},
+		{Type: "telegram", Token: "123:secret"},
+		{Type: "carrier-pigeon"},
+	} {
+		if _, err := NewNotifier(config); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ===================== OPML Export =======================
+// =========================================================
+
+type OPMLOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Category string        `xml:"category,attr,omitempty"`
+	Outlines []OPMLOutline `xml:"outline"`
+}
+
+type OPMLHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type OPML struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Head    OPMLHead      `xml:"head"`
+	Body    []OPMLOutline `xml:"body>outline"`
+}
+
+func OPMLFileName(group string) string {
+	if group == "" {
+		return "podcasts.opml"
+	}
+	// the group name is user input, keep only characters safe in a file name
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r == ' ' || r == '_' || r == '.':
+			return '_'
+		}
+		return -1
+	}, group)
+	safe = strings.Trim(safe, "_")
+	if safe == "" {
+		safe = "group"
+	}
+	return "podcasts-" + safe + ".opml"
+}
+
+// FeedOutlines lists every PodcastDownload and RSSDownload feed, optionally
+// only those in the given group. RSSDownload entries sharing a ChannelID
+// share a feed and are only listed once.
+func FeedOutlines(group string) []OPMLOutline {
+	current := SettingsSnapshot()
+	var outlines []OPMLOutline
+	seen := map[string]bool{}
+
+	addFeed := func(pName string, pChannelID string, pYouTubeURL string, pOptions FeedOptions) {
+		if pChannelID == "" || seen[pChannelID] {
+			return
+		}
+		if group != "" && !strings.EqualFold(strings.TrimSpace(pOptions.Group), group) {
+			return
+		}
+		// the OPML file is public, a private feed's URL would leak its token
+		if settingBool(pOptions.Private) {
+			return
+		}
+		seen[pChannelID] = true
+		outline := OPMLOutline{
+			Text:    pName,
+			Title:   pName,
+			Type:    "rss",
+			XMLURL:  FeedURL(RSSFileName(pChannelID)),
+			HTMLURL: pYouTubeURL,
+		}
+		if pOptions.Group != "" {
+			outline.Category = "/" + pOptions.Group
+		}
+		outlines = append(outlines, outline)
+	}
+
+	for _, podcast := range current.PodcastDownload {
+		addFeed(podcast.Name, podcast.ChannelID, podcast.YouTubeURL, podcast.FeedOptions)
+	}
+	for _, rss := range current.RSSDownload {
+		addFeed(rss.Name, rss.ChannelID, "", rss.FeedOptions)
+	}
+	if send, ok := SendFeedEntry(); ok {
+		addFeed(send.Name, send.ChannelID, "", send.FeedOptions)
+	}
+	return outlines
+}
+
+// ExportOPML writes an OPML 2.0 subscription list into RSSFolder and returns
+// its path.
+func ExportOPML(group string) (string, error) {
+	title := "DownloadYouTubeGo Podcasts"
+	if group != "" {
+		title += " (" + group + ")"
+	}
+
+	opml := OPML{
+		Version: "2.0",
+		Head:    OPMLHead{Title: title, DateCreated: time.Now().Format(time.RFC1123Z)},
+		Body:    FeedOutlines(group),
+	}
+
+	opmlData, err := xml.MarshalIndent(opml, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	opmlPath := settingsXML.RSSFolder + OPMLFileName(group)
+	if err := WriteFileAtomic(opmlPath, append([]byte(xml.Header), opmlData...), 0666); err != nil {
+		return "", err
+	}
+	log.Println("OPML written: " + opmlPath + " (" + FeedURL(OPMLFileName(group)) + ")")
+	return opmlPath, nil
+}
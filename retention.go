@@ -0,0 +1,315 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =========================================================
+// ============= Retention after Complete Fetch ============
+// =========================================================
+
+// fetchedRange is a byte range [Start, End) of a media file sent to a client.
+type fetchedRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// FetchProgress is the part of an episode's media file sent so far, kept in
+// the feed state since podcast apps often download an enclosure in several
+// Range requests, which may span a restart of the server.
+type FetchProgress struct {
+	Size    int64          `json:"size"`
+	Ranges  []fetchedRange `json:"ranges"`
+	Updated time.Time      `json:"updated"`
+}
+
+// fetchProgressMaxAge drops the progress of fetches nobody finished.
+const fetchProgressMaxAge = 7 * 24 * time.Hour
+
+// countingResponseWriter remembers the status and body bytes of a response.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's io.ReaderFrom, which http.ServeContent
+// uses to send files with sendfile.
+func (w *countingResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.written += n
+	return n, err
+}
+
+// Unwrap gives http.ResponseController the underlying writer.
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// enclosureEpisode maps a file below MediaFolder to its feed and episode, for
+// <ChannelID>/<id>.<media extension> only.
+func enclosureEpisode(filePath string) (string, string, bool) {
+	rel, err := filepath.Rel(filepath.Clean(settingsXML.MediaFolder), filePath)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 2 || parts[0] == ".." {
+		return "", "", false
+	}
+	switch strings.ToLower(filepath.Ext(parts[1])) {
+	case ".mp4", ".m4a", ".mp3", ".webm", ".ogg", ".opus", ".mkv", ".mov":
+	default:
+		return "", "", false
+	}
+	return parts[0], strings.TrimSuffix(parts[1], filepath.Ext(parts[1])), true
+}
+
+// servedRange reads which part of the file a finished response carried.
+func servedRange(w *countingResponseWriter) (fetchedRange, bool) {
+	switch w.status {
+	case http.StatusOK:
+		return fetchedRange{0, w.written}, true
+	case http.StatusPartialContent:
+		// "bytes <start>-<end>/<size>"; multipart responses have no header
+		contentRange := strings.TrimPrefix(w.Header().Get("Content-Range"), "bytes ")
+		dash := strings.Index(contentRange, "-")
+		if dash < 0 {
+			return fetchedRange{}, false
+		}
+		start, err := strconv.ParseInt(contentRange[:dash], 10, 64)
+		if err != nil {
+			return fetchedRange{}, false
+		}
+		return fetchedRange{start, start + w.written}, true
+	}
+	return fetchedRange{}, false
+}
+
+// addFetchedRange merges a range into ranges, which stay sorted and disjoint.
+func addFetchedRange(ranges []fetchedRange, added fetchedRange) []fetchedRange {
+	ranges = append(ranges, added)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next.Start <= last.End {
+			if next.End > last.End {
+				last.End = next.End
+			}
+			continue
+		}
+		merged = append(merged, next)
+	}
+	return merged
+}
+
+// fetchProgressSaveDelay is how long the progress of partial fetches stays in
+// memory only. Podcast apps stream an episode in many small Range requests,
+// and each would otherwise rewrite the state file. Progress younger than
+// this is lost when the server is stopped.
+const fetchProgressSaveDelay = 30 * time.Second
+
+// fetchMu guards the fetch progress the server keeps in memory. It is read
+// from the feed state once per feed, merged here and written back when an
+// episode is complete or fetchProgressSaveDelay after a partial fetch.
+var fetchMu sync.Mutex
+var fetchProgress = map[string]map[string]FetchProgress{}
+var fetchedEpisodes = map[string]map[string]bool{}
+var fetchDirty = map[string]bool{}
+var fetchSaveTimer *time.Timer
+
+// loadFetchProgress reads a feed's saved progress into memory. fetchMu must
+// be held.
+func loadFetchProgress(pChannelID string) {
+	if _, loaded := fetchProgress[pChannelID]; loaded {
+		return
+	}
+	feedState := GetFeedState(pChannelID)
+	progress := map[string]FetchProgress{}
+	for id, saved := range feedState.Fetching {
+		progress[id] = saved
+	}
+	fetched := map[string]bool{}
+	for id := range feedState.Fetched {
+		fetched[id] = true
+	}
+	fetchProgress[pChannelID] = progress
+	fetchedEpisodes[pChannelID] = fetched
+}
+
+// saveFetchProgress writes a feed's progress, and with completeID set that
+// episode's complete fetch, to the feed state. fetchMu must be held.
+func saveFetchProgress(pChannelID string, completeID string, now time.Time) {
+	saved := map[string]FetchProgress{}
+	for id, progress := range fetchProgress[pChannelID] {
+		saved[id] = progress
+	}
+	UpdateFeedState(pChannelID, func(feed *FeedState) {
+		if len(saved) == 0 {
+			feed.Fetching = nil
+		} else {
+			feed.Fetching = saved
+		}
+		if completeID != "" {
+			if feed.Fetched == nil {
+				feed.Fetched = map[string]time.Time{}
+			}
+			if _, fetched := feed.Fetched[completeID]; !fetched {
+				feed.Fetched[completeID] = now
+			}
+		}
+	})
+	delete(fetchDirty, pChannelID)
+}
+
+// FlushFetchProgress saves the progress of partial fetches that is only in
+// memory.
+func FlushFetchProgress() {
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	if fetchSaveTimer != nil {
+		fetchSaveTimer.Stop()
+		fetchSaveTimer = nil
+	}
+	for pChannelID := range fetchDirty {
+		saveFetchProgress(pChannelID, "", time.Now())
+	}
+}
+
+// TrackEnclosureFetch records the first time the whole of an episode's media
+// file has been sent to clients.
+func TrackEnclosureFetch(filePath string, size int64, w *countingResponseWriter) {
+	pChannelID, id, ok := enclosureEpisode(filePath)
+	if !ok || size == 0 {
+		return
+	}
+	served, ok := servedRange(w)
+	if !ok || served.End <= served.Start {
+		return
+	}
+
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	loadFetchProgress(pChannelID)
+	if fetchedEpisodes[pChannelID][id] {
+		return
+	}
+
+	now := time.Now()
+	feedProgress := fetchProgress[pChannelID]
+	for other, progress := range feedProgress {
+		if now.Sub(progress.Updated) > fetchProgressMaxAge {
+			delete(feedProgress, other)
+			fetchDirty[pChannelID] = true
+		}
+	}
+
+	progress := feedProgress[id]
+	if progress.Size != size {
+		// a new file under the same name starts over
+		progress = FetchProgress{Size: size}
+	}
+	progress.Ranges = addFetchedRange(progress.Ranges, served)
+	progress.Updated = now
+	if progress.Ranges[0].Start > 0 || progress.Ranges[0].End < size {
+		feedProgress[id] = progress
+		fetchDirty[pChannelID] = true
+		if fetchSaveTimer == nil {
+			fetchSaveTimer = time.AfterFunc(fetchProgressSaveDelay, FlushFetchProgress)
+		}
+		return
+	}
+
+	delete(feedProgress, id)
+	fetchedEpisodes[pChannelID][id] = true
+	saveFetchProgress(pChannelID, id, now)
+	log.Println("Episode fetched completely: " + pChannelID + "/" + id)
+}
+
+// fetchRetention is the DeleteAfterFetchDays option: delete an episode this
+// long after its first complete fetch instead of 168 hours after download.
+func fetchRetention(pOptions FeedOptions) (time.Duration, bool) {
+	if strings.TrimSpace(pOptions.DeleteAfterFetchDays) == "" {
+		return 0, false
+	}
+	days := settingInt(pOptions.DeleteAfterFetchDays, -1)
+	if days < 0 {
+		return 0, false
+	}
+	return time.Duration(days) * 24 * time.Hour, true
+}
+
+// EpisodeExpired decides whether DeleteOldFiles removes an episode. With
+// DeleteAfterFetchDays set, episodes nobody has fetched are kept.
+func EpisodeExpired(feedState FeedState, id string, modTime time.Time, pOptions FeedOptions) bool {
+	keep, ok := fetchRetention(pOptions)
+	if !ok {
+		return isOlderThan(modTime)
+	}
+	fetched, ok := feedState.Fetched[id]
+	return ok && time.Since(fetched) > keep
+}
+
+// RemoveEpisodes drops the items of deleted episodes from a channel's feed
+// and archive pages.
+func RemoveEpisodes(sRSSFolder string, pChannelID string, ids []string, pOptions FeedOptions) error {
+	if len(ids) == 0 || !IsValid(sRSSFolder+RSSFileName(pChannelID)) {
+		return nil
+	}
+	feedLock := LockFeed(pChannelID)
+	defer Unlock(feedLock)
+
+	doc, err := LoadFeedSet(sRSSFolder, pChannelID)
+	if err != nil {
+		return err
+	}
+	removeIDs := map[string]bool{}
+	for _, id := range ids {
+		removeIDs[id] = true
+	}
+	var kept []FeedItem
+	for _, item := range doc.Items {
+		if item.MediaID != "" && removeIDs[item.MediaID] {
+			log.Println("Remove item from RSS file: " + item.MediaID)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if len(kept) == len(doc.Items) {
+		return nil
+	}
+	doc.Items = kept
+	return WriteFeedSet(sRSSFolder, pChannelID, doc, pOptions)
+}
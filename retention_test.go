@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddFetchedRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []fetchedRange
+		added  fetchedRange
+		want   []fetchedRange
+	}{
+		{"first range", nil, fetchedRange{0, 10}, []fetchedRange{{0, 10}}},
+		{"disjoint after", []fetchedRange{{0, 10}}, fetchedRange{20, 30}, []fetchedRange{{0, 10}, {20, 30}}},
+		{"disjoint before", []fetchedRange{{20, 30}}, fetchedRange{0, 10}, []fetchedRange{{0, 10}, {20, 30}}},
+		{"adjacent joins", []fetchedRange{{0, 10}}, fetchedRange{10, 20}, []fetchedRange{{0, 20}}},
+		{"overlap extends", []fetchedRange{{0, 10}}, fetchedRange{5, 15}, []fetchedRange{{0, 15}}},
+		{"contained adds nothing", []fetchedRange{{0, 30}}, fetchedRange{5, 15}, []fetchedRange{{0, 30}}},
+		{"fills a gap", []fetchedRange{{0, 10}, {20, 30}}, fetchedRange{10, 20}, []fetchedRange{{0, 30}}},
+		{"covers several", []fetchedRange{{5, 10}, {20, 25}, {40, 50}}, fetchedRange{0, 30}, []fetchedRange{{0, 30}, {40, 50}}},
+	}
+	for _, tt := range tests {
+		if got := addFetchedRange(tt.ranges, tt.added); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServedRange(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		contentRange string
+		written      int64
+		want         fetchedRange
+		wantOK       bool
+	}{
+		{"whole file", http.StatusOK, "", 100, fetchedRange{0, 100}, true},
+		{"partial", http.StatusPartialContent, "bytes 50-99/200", 50, fetchedRange{50, 100}, true},
+		{"partial cut short", http.StatusPartialContent, "bytes 50-199/200", 10, fetchedRange{50, 60}, true},
+		{"multipart has no header", http.StatusPartialContent, "", 100, fetchedRange{}, false},
+		{"broken header", http.StatusPartialContent, "bytes x-9/10", 10, fetchedRange{}, false},
+		{"not modified", http.StatusNotModified, "", 0, fetchedRange{}, false},
+		{"not found", http.StatusNotFound, "", 19, fetchedRange{}, false},
+	}
+	for _, tt := range tests {
+		w := &countingResponseWriter{ResponseWriter: httptest.NewRecorder(), status: tt.status, written: tt.written}
+		if tt.contentRange != "" {
+			w.Header().Set("Content-Range", tt.contentRange)
+		}
+		got, ok := servedRange(w)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestEpisodeExpired(t *testing.T) {
+	now := time.Now()
+	state := FeedState{Fetched: map[string]time.Time{
+		"old":    now.Add(-10 * 24 * time.Hour),
+		"recent": now.Add(-2 * 24 * time.Hour),
+	}}
+	tests := []struct {
+		name    string
+		id      string
+		modTime time.Time
+		days    string
+		want    bool
+	}{
+		{"without the option after a week", "never", now.Add(-8 * 24 * time.Hour), "", true},
+		{"without the option within a week", "old", now.Add(-6 * 24 * time.Hour), "", false},
+		{"never fetched is kept", "never", now.Add(-100 * 24 * time.Hour), "3", false},
+		{"fetched long enough ago", "old", now, "3", true},
+		{"fetched recently", "recent", now.Add(-100 * 24 * time.Hour), "3", false},
+		{"zero days deletes once fetched", "recent", now, "0", true},
+		{"invalid option falls back to a week", "never", now.Add(-8 * 24 * time.Hour), "soon", true},
+	}
+	for _, tt := range tests {
+		if got := EpisodeExpired(state, tt.id, tt.modTime, FeedOptions{DeleteAfterFetchDays: tt.days}); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCountingResponseWriterReadFrom(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := &countingResponseWriter{ResponseWriter: recorder}
+	n, err := w.ReadFrom(strings.NewReader("episode"))
+	if err != nil || n != 7 || w.written != 7 || w.status != http.StatusOK {
+		t.Errorf("ReadFrom = %d, %v; written %d, status %d", n, err, w.written, w.status)
+	}
+	if recorder.Body.String() != "episode" {
+		t.Errorf("body = %q", recorder.Body.String())
+	}
+	if w.Unwrap() != http.ResponseWriter(recorder) {
+		t.Error("Unwrap does not return the underlying writer")
+	}
+}
+
+// restartFetchTracking forgets the progress kept in memory, like a restarted
+// server.
+func restartFetchTracking() {
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	fetchProgress = map[string]map[string]FetchProgress{}
+	fetchedEpisodes = map[string]map[string]bool{}
+	fetchDirty = map[string]bool{}
+}
+
+func TestTrackEnclosureFetchAcrossRestart(t *testing.T) {
+	oldSettings := settingsXML
+	defer func() { settingsXML = oldSettings }()
+	dir := t.TempDir()
+	settingsXML.Config = dir + "/"
+	settingsXML.MediaFolder = dir + "/media/"
+	os.MkdirAll(settingsXML.MediaFolder+"UC1", 0777)
+	file := settingsXML.MediaFolder + "UC1/abc.mp4"
+	restartFetchTracking()
+	defer restartFetchTracking()
+
+	fetchFile := func(file string, contentRange string, written int64) {
+		w := &countingResponseWriter{ResponseWriter: httptest.NewRecorder(), status: http.StatusPartialContent, written: written}
+		w.Header().Set("Content-Range", contentRange)
+		TrackEnclosureFetch(file, 100, w)
+	}
+	fetch := func(contentRange string, written int64) { fetchFile(file, contentRange, written) }
+
+	// partial fetches are merged in memory and saved later
+	fetch("bytes 0-24/100", 25)
+	fetch("bytes 25-49/100", 25)
+	if _, ok := GetFeedState("UC1").Fetching["abc"]; ok {
+		t.Error("partial fetch saved before the save delay")
+	}
+	FlushFetchProgress()
+	progress := GetFeedState("UC1").Fetching["abc"]
+	if !reflect.DeepEqual(progress.Ranges, []fetchedRange{{0, 50}}) {
+		t.Fatalf("progress after the first half = %+v", progress)
+	}
+
+	// progress lives in the state file, so a restarted server continues it
+	restartFetchTracking()
+	fetch("bytes 50-99/100", 50)
+	state := GetFeedState("UC1")
+	if _, ok := state.Fetched["abc"]; !ok {
+		t.Fatalf("episode not fetched after both halves: %+v", state)
+	}
+	if _, ok := state.Fetching["abc"]; ok {
+		t.Error("progress kept after the complete fetch")
+	}
+
+	// partial fetches nobody continues are dropped
+	UpdateFeedState("UC1", func(feed *FeedState) {
+		feed.Fetching = map[string]FetchProgress{"stale": {Size: 100, Ranges: []fetchedRange{{0, 10}}, Updated: time.Now().Add(-fetchProgressMaxAge - time.Hour)}}
+	})
+	restartFetchTracking()
+	fetch("bytes 0-9/100", 10)
+	fetchFile(settingsXML.MediaFolder+"UC1/def.mp4", "bytes 0-9/100", 10)
+	FlushFetchProgress()
+	fetching := GetFeedState("UC1").Fetching
+	if _, ok := fetching["stale"]; ok {
+		t.Error("stale progress not expired")
+	}
+	if _, ok := fetching["abc"]; ok {
+		t.Error("a fetched episode is tracked again")
+	}
+	if _, ok := fetching["def"]; !ok {
+		t.Error("new partial fetch not tracked")
+	}
+}
+
+func TestDeleteOldFilesWithoutMediaFolder(t *testing.T) {
+	// a feed whose first run failed has no media folder yet
+	if err := DeleteOldFiles(t.TempDir()+"/UC1/", FeedOptions{}); err != nil {
+		t.Errorf("DeleteOldFiles = %v, want nothing to do", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// =========================================================
+// ==================== Send to Feed =======================
+// =========================================================
+
+// SendFeedEntry is the SendFeed settings entry with its defaults filled in:
+// ChannelID "Personal", mp4 at best quality and its own download archive.
+func SendFeedEntry() (YouTubeDownload, bool) {
+	if settingsXML.SendFeed == nil {
+		return YouTubeDownload{}, false
+	}
+	feed := *settingsXML.SendFeed
+	if feed.ChannelID == "" {
+		feed.ChannelID = "Personal"
+	}
+	if feed.Name == "" {
+		feed.Name = feed.ChannelID
+	}
+	if feed.FileFormat == "" {
+		feed.FileFormat = "mp4"
+	}
+	if feed.FileQuality == "" {
+		feed.FileQuality = "best"
+	}
+	if feed.DownloadArchive == "" {
+		feed.DownloadArchive = settingsXML.Config + "youtube-dl-archive-" + feed.ChannelID + ".txt"
+	}
+	// the feed has no channel to poll
+	feed.YouTubeURL = ""
+	return feed, true
+}
+
+// writeSendFeedChannelJSON stands in for the channel JSON yt-dlp writes for
+// a real channel, so the channel template has a description and link.
+func writeSendFeedChannelJSON(feed YouTubeDownload) error {
+	dir := settingsXML.MediaFolder + feed.ChannelID + "/"
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	info := map[string]interface{}{
+		"id":          feed.ChannelID,
+		"title":       feed.Name,
+		"description": "Videos sent to " + feed.Name,
+		"channel_url": settingsXML.HTTPHost,
+		"webpage_url": settingsXML.HTTPHost,
+		"thumbnails":  []interface{}{},
+	}
+	content, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(dir+feed.ChannelID+".info.json", content, 0666)
+}
+
+// SendToFeed downloads one video (any URL yt-dlp supports) into the SendFeed
+// feed through Run_YTDLP, which sends the usual Pushover notification.
+func SendToFeed(videoURL string) error {
+	feed, ok := SendFeedEntry()
+	if !ok {
+		return errors.New("no SendFeed in " + settingsPath)
+	}
+	parsed, err := url.Parse(videoURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("not an http(s) URL: " + videoURL)
+	}
+
+	if !IsValid(feed.DownloadArchive) {
+		archive, err := os.OpenFile(feed.DownloadArchive, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		archive.Close()
+	}
+	if err := writeSendFeedChannelJSON(feed); err != nil {
+		return err
+	}
+
+	log.Println("Send to " + feed.Name + ": " + videoURL)
+	return RunPodcastDownload(feed, videoURL)
+}
+
+func sendAuthorized(r *http.Request) bool {
+	if apiAuthorized(r) {
+		return true
+	}
+	// bookmarklets cannot set headers
+	given := r.URL.Query().Get("key")
+	return settingsXML.APIKey != "" && subtle.ConstantTimeCompare([]byte(settingsXML.APIKey), []byte(given)) == 1
+}
+
+// sendHandler accepts a URL as ?url=, a form field or {"url": …} and queues
+// it for the SendFeed feed. The download runs after any cron or API run.
+func sendHandler(w http.ResponseWriter, r *http.Request) {
+	if !sendAuthorized(r) {
+		writeAPIError(w, http.StatusUnauthorized, "missing or wrong API key")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	videoURL := r.URL.Query().Get("url")
+	if r.Method == http.MethodPost {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			var body struct {
+				URL string `json:"url"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			videoURL = body.URL
+		} else if formURL := r.FormValue("url"); formURL != "" {
+			videoURL = formURL
+		}
+	}
+	videoURL = strings.TrimSpace(videoURL)
+
+	if _, ok := SendFeedEntry(); !ok {
+		writeAPIError(w, http.StatusNotFound, "no SendFeed configured")
+		return
+	}
+	if parsed, err := url.Parse(videoURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		writeAPIError(w, http.StatusBadRequest, "url must be an http(s) URL")
+		return
+	}
+
+	go func() {
+		runLock, err := LockFile(lockPath("DownloadYouTubeGo"), true)
+		if err != nil {
+			log.Println("Send run lock failed: " + err.Error())
+			return
+		}
+		defer Unlock(runLock)
+		if err := SendToFeed(videoURL); err != nil {
+			log.Println("Send failed: " + videoURL + ": " + err.Error())
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued", "url": videoURL})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// =========================================================
+// ================ Feed and Media HTTP Server =============
+// =========================================================
+
+var serverContentTypes = map[string]string{
+	".xml":  "application/rss+xml; charset=utf-8",
+	".opml": "text/x-opml; charset=utf-8",
+	".json": "application/json; charset=utf-8",
+	".mp4":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".webm": "video/webm",
+	".vtt":  "text/vtt; charset=utf-8",
+	".srt":  "application/x-subrip; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+}
+
+// serverBasePath is the path part of HTTPHost, so the server answers on the
+// same URLs the feeds point at ("/" for http://host:port/).
+func serverBasePath() string {
+	base := "/"
+	if parsed, err := url.Parse(settingsXML.HTTPHost); err == nil && parsed.Path != "" {
+		base = parsed.Path
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base
+}
+
+func serverContentType(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if strings.HasSuffix(fileName, "Atom.xml") {
+		return "application/atom+xml; charset=utf-8"
+	}
+	if ctype, ok := serverContentTypes[ext]; ok {
+		return ctype
+	}
+	if ctype := mime.TypeByExtension(ext); ctype != "" {
+		return ctype
+	}
+	return "application/octet-stream"
+}
+
+func isCompressible(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".xml", ".opml", ".json", ".vtt", ".srt", ".txt":
+		return true
+	}
+	return false
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// serveFile sends one file with Range, ETag and Last-Modified support, and
+// gzips text formats for clients that accept it.
+func serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() || strings.HasPrefix(filepath.Base(filePath), ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", serverContentType(filePath))
+	etag := "\"" + strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36) + "\""
+
+	if isCompressible(filePath) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && r.Header.Get("Range") == "" {
+			etag = strings.TrimSuffix(etag, "\"") + "-gzip\""
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			if r.Method == http.MethodHead {
+				return
+			}
+			file, err := os.Open(filePath)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer file.Close()
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			io.Copy(gz, file)
+			return
+		}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("ETag", etag)
+	counting := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(counting, r, filepath.Base(filePath), info.ModTime(), file)
+	if r.Method == http.MethodGet {
+		TrackEnclosureFetch(filePath, info.Size(), counting)
+	}
+}
+
+// folderHandler serves the files below root at URL prefix. feedFor names the
+// feed a file belongs to, so private feeds can check the request's token.
+func folderHandler(prefix string, root string, feedFor func(rel string) (ConfiguredFeed, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rel := path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+		if rel == "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if feed, ok := feedFor(rel); ok && !TokenAllowed(feed, r.URL.Query().Get("token")) {
+			http.NotFound(w, r)
+			return
+		}
+		serveFile(w, r, filepath.Join(root, filepath.FromSlash(rel)))
+	})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Println("HTTP " + r.Method + " " + r.URL.Path + " (" + r.RemoteAddr + ")")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewServerMux maps MediaFolder to <base>podcasts/ and RSSFolder to
+// <base><RSSPath>, the URLs Run_YTDLP writes into the feeds, and serves the
+// dashboard at <base>dashboard/ (behind APIKey when set), with WebSub set the
+// hub callbacks at <base>websub/ and with APIKey set the API at <base>api/,
+// send-to-feed at <base>send and metrics at <base>metrics.
+func NewServerMux() *http.ServeMux {
+	base := serverBasePath()
+	mux := http.NewServeMux()
+
+	mediaPrefix := base + "podcasts/"
+	mux.Handle(mediaPrefix, folderHandler(mediaPrefix, settingsXML.MediaFolder, mediaChannelID))
+	log.Println("Serve " + settingsXML.MediaFolder + " at " + mediaPrefix)
+
+	rssPrefix := base + settingsXML.RSSPath
+	if !strings.HasSuffix(rssPrefix, "/") {
+		rssPrefix += "/"
+	}
+	mux.Handle(rssPrefix, folderHandler(rssPrefix, settingsXML.RSSFolder, func(rel string) (ConfiguredFeed, bool) {
+		return feedFileChannelID(path.Base(rel))
+	}))
+	log.Println("Serve " + settingsXML.RSSFolder + " at " + rssPrefix)
+
+	mux.HandleFunc(base+"dashboard/", dashboardHandler)
+	log.Println("Dashboard at " + base + "dashboard/")
+
+	if settingBool(settingsXML.WebSub) {
+		mux.Handle(base+"websub/", webSubHandler(base+"websub/"))
+		log.Println("WebSub callbacks at " + base + "websub/")
+	}
+
+	if settingsXML.APIKey != "" {
+		mux.Handle(base+"api/", apiHandler(base+"api/"))
+		log.Println("API at " + base + "api/")
+		mux.HandleFunc(base+"send", sendHandler)
+		log.Println("Send to feed at " + base + "send")
+		mux.HandleFunc(base+"metrics", metricsHandler)
+		log.Println("Metrics at " + base + "metrics")
+	}
+	return mux
+}
+
+// Serve runs the embedded HTTP server until it fails. listen defaults to
+// HTTPListen, then ":8080".
+func Serve(listen string) error {
+	if listen == "" {
+		listen = settingsXML.HTTPListen
+	}
+	if listen == "" {
+		listen = ":8080"
+	}
+
+	server := &http.Server{Addr: listen, Handler: logRequests(NewServerMux())}
+	if settingBool(settingsXML.WebSub) {
+		LockServer()
+		go RunWebSubRenewal()
+	}
+	if _, ok := digestInterval(); ok {
+		go RunEmailDigest()
+	}
+	log.Println("Listening on " + listen)
+	return server.ListenAndServe()
+}
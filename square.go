@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// =========================================================
+// ================ Square Podcast Artwork =================
+// =========================================================
+
+// Apple Podcasts wants square JPEG or PNG artwork of 1400 to 3000 px.
+const (
+	minArtworkSize = 1400
+	maxArtworkSize = 3000
+)
+
+// squareArtworkOptions reads SquareArtwork ("crop", "letterbox" or "off", the
+// default, which keeps the thumbnails as they are), ArtworkSize and
+// ArtworkTitle.
+func squareArtworkOptions(pOptions FeedOptions) (string, int, bool) {
+	mode := strings.ToLower(strings.TrimSpace(pOptions.SquareArtwork))
+	if mode != "crop" && mode != "letterbox" {
+		mode = "off"
+	}
+	size := settingInt(pOptions.ArtworkSize, minArtworkSize)
+	if size < minArtworkSize {
+		size = minArtworkSize
+	}
+	if size > maxArtworkSize {
+		size = maxArtworkSize
+	}
+	return mode, size, settingBool(pOptions.ArtworkTitle)
+}
+
+// artworkWidth is the width of an image file, 0 when it cannot be read.
+func artworkWidth(imagePath string) int {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0
+	}
+	return config.Width
+}
+
+// SquareArtwork renders the square version of a cached artwork file next to
+// it and returns its file name and width. The name holds the options, so a
+// changed option renders it again. With SquareArtwork off the cached file is
+// served as it is, with its decoded width.
+func SquareArtwork(pChannelID string, file string, title string, pOptions FeedOptions) (string, int, error) {
+	mode, size, withTitle := squareArtworkOptions(pOptions)
+	if mode == "off" {
+		return file, artworkWidth(artworkDir(pChannelID) + file), nil
+	}
+	dir := artworkDir(pChannelID)
+	name := strings.TrimSuffix(file, filepath.Ext(file))
+	squareFile := name + ".square-" + strconv.Itoa(size) + "-" + mode
+	if withTitle {
+		squareFile += "-title"
+	}
+	squareFile += ".jpg"
+	if IsValid(dir + squareFile) {
+		return squareFile, size, nil
+	}
+
+	source, err := os.Open(dir + file)
+	if err != nil {
+		return "", 0, err
+	}
+	src, _, err := image.Decode(source)
+	source.Close()
+	if err != nil {
+		return "", 0, err
+	}
+
+	square := renderSquare(src, size, mode)
+	if withTitle {
+		drawTitle(square, title)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, square, &jpeg.Options{Quality: 90}); err != nil {
+		return "", 0, err
+	}
+	if err := WriteFileAtomic(dir+squareFile, buf.Bytes(), 0644); err != nil {
+		return "", 0, err
+	}
+
+	// drop renders with earlier options
+	old, _ := filepath.Glob(dir + name + ".square-*")
+	for _, oldFile := range old {
+		if filepath.Base(oldFile) != squareFile {
+			os.Remove(oldFile)
+		}
+	}
+	return squareFile, size, nil
+}
+
+// renderSquare center-crops src to a square, or letterboxes it on its average
+// colour, and scales it to size.
+func renderSquare(src image.Image, size int, mode string) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+	w, h := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	square := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	if mode == "letterbox" {
+		draw.Draw(square, square.Bounds(), &image.Uniform{averageColor(rgba)}, image.Point{}, draw.Src)
+		dw, dh := size, h*size/w
+		if h > w {
+			dw, dh = w*size/h, size
+		}
+		target := image.Rect((size-dw)/2, (size-dh)/2, (size-dw)/2+dw, (size-dh)/2+dh)
+		scaleInto(square, target, rgba, rgba.Bounds())
+		return square
+	}
+
+	side := w
+	if h < side {
+		side = h
+	}
+	crop := image.Rect((w-side)/2, (h-side)/2, (w-side)/2+side, (h-side)/2+side)
+	scaleInto(square, square.Bounds(), rgba, crop)
+	return square
+}
+
+func averageColor(img *image.RGBA) color.RGBA {
+	var r, g, b, n uint64
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r += uint64(img.Pix[i])
+		g += uint64(img.Pix[i+1])
+		b += uint64(img.Pix[i+2])
+		n++
+	}
+	if n == 0 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}
+}
+
+// scaleInto resamples the from rectangle of src into the to rectangle of dst:
+// box averaging when shrinking, bilinear when enlarging.
+func scaleInto(dst *image.RGBA, to image.Rectangle, src *image.RGBA, from image.Rectangle) {
+	if to.Empty() || from.Empty() {
+		return
+	}
+	scaleX := float64(from.Dx()) / float64(to.Dx())
+	scaleY := float64(from.Dy()) / float64(to.Dy())
+	for dy := 0; dy < to.Dy(); dy++ {
+		for dx := 0; dx < to.Dx(); dx++ {
+			var pixel [4]float64
+			if scaleX > 1 || scaleY > 1 {
+				pixel = boxSample(src, from, float64(dx)*scaleX, float64(dy)*scaleY, scaleX, scaleY)
+			} else {
+				pixel = bilinearSample(src, from, (float64(dx)+0.5)*scaleX-0.5, (float64(dy)+0.5)*scaleY-0.5)
+			}
+			offset := dst.PixOffset(to.Min.X+dx, to.Min.Y+dy)
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(pixel[c] + 0.5)
+			}
+		}
+	}
+}
+
+func clampInt(v int, lo int, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func boxSample(src *image.RGBA, from image.Rectangle, x float64, y float64, w float64, h float64) [4]float64 {
+	x0, y0 := int(x), int(y)
+	x1, y1 := clampInt(int(x+w+0.999), x0+1, from.Dx()), clampInt(int(y+h+0.999), y0+1, from.Dy())
+	var sum [4]float64
+	for sy := y0; sy < y1; sy++ {
+		for sx := x0; sx < x1; sx++ {
+			offset := src.PixOffset(from.Min.X+sx, from.Min.Y+sy)
+			for c := 0; c < 4; c++ {
+				sum[c] += float64(src.Pix[offset+c])
+			}
+		}
+	}
+	n := float64((x1 - x0) * (y1 - y0))
+	for c := range sum {
+		sum[c] /= n
+	}
+	return sum
+}
+
+func bilinearSample(src *image.RGBA, from image.Rectangle, x float64, y float64) [4]float64 {
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	x0, y0 := clampInt(int(x), 0, from.Dx()-1), clampInt(int(y), 0, from.Dy()-1)
+	x1, y1 := clampInt(x0+1, 0, from.Dx()-1), clampInt(y0+1, 0, from.Dy()-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+	if fx > 1 {
+		fx = 1
+	}
+	if fy > 1 {
+		fy = 1
+	}
+	var pixel [4]float64
+	for c := 0; c < 4; c++ {
+		at := func(px int, py int) float64 {
+			return float64(src.Pix[src.PixOffset(from.Min.X+px, from.Min.Y+py)+c])
+		}
+		top := at(x0, y0)*(1-fx) + at(x1, y0)*fx
+		bottom := at(x0, y1)*(1-fx) + at(x1, y1)*fx
+		pixel[c] = top*(1-fy) + bottom*fy
+	}
+	return pixel
+}
+
+// =========================================================
+// ===================== Title Overlay =====================
+// =========================================================
+
+// artworkFont is a 5x7 bitmap font, one byte per row with the leftmost pixel
+// in bit 4. Letters are drawn in upper case; other characters are left out.
+var artworkFont = map[rune][7]byte{
+	'A':  {0x0E, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'B':  {0x1E, 0x11, 0x11, 0x1E, 0x11, 0x11, 0x1E},
+	'C':  {0x0E, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0E},
+	'D':  {0x1C, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1C},
+	'E':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x1F},
+	'F':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x10},
+	'G':  {0x0E, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0F},
+	'H':  {0x11, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'I':  {0x0E, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0C},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1F},
+	'M':  {0x11, 0x1B, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0E, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'P':  {0x1E, 0x11, 0x11, 0x1E, 0x10, 0x10, 0x10},
+	'Q':  {0x0E, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0D},
+	'R':  {0x1E, 0x11, 0x11, 0x1E, 0x14, 0x12, 0x11},
+	'S':  {0x0F, 0x10, 0x10, 0x0E, 0x01, 0x01, 0x1E},
+	'T':  {0x1F, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0A, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0A},
+	'X':  {0x11, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x0A, 0x04, 0x04, 0x04, 0x04},
+	'Z':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1F},
+	'0':  {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1':  {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3':  {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4':  {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5':  {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6':  {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9':  {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	' ':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'-':  {0x00, 0x00, 0x00, 0x1F, 0x00, 0x00, 0x00},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1F},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x0C, 0x0C},
+	',':  {0x00, 0x00, 0x00, 0x00, 0x0C, 0x04, 0x08},
+	'\'': {0x0C, 0x04, 0x08, 0x00, 0x00, 0x00, 0x00},
+	'!':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04},
+	'?':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04},
+	'&':  {0x0C, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0D},
+	':':  {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x0C, 0x00},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'/':  {0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00},
+	'+':  {0x00, 0x04, 0x04, 0x1F, 0x04, 0x04, 0x00},
+	'#':  {0x0A, 0x0A, 0x1F, 0x0A, 0x1F, 0x0A, 0x0A},
+	'|':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+}
+
+// titleRunes keeps the characters artworkFont can draw.
+func titleRunes(title string) []rune {
+	var runes []rune
+	for _, r := range title {
+		r = unicode.ToUpper(r)
+		if unicode.IsSpace(r) {
+			r = ' '
+		}
+		if _, ok := artworkFont[r]; ok {
+			runes = append(runes, r)
+		}
+	}
+	return []rune(strings.Join(strings.Fields(string(runes)), " "))
+}
+
+// fitTitle picks the largest pixel scale at which runes fit 90% of the
+// width of a size px image, shortening them with "..." when even the
+// smallest scale is too wide.
+func fitTitle(runes []rune, size int) ([]rune, int) {
+	maxWidth := size * 9 / 10
+	scale := size / 100
+	minScale := size / 200
+	for scale > minScale && (6*len(runes)-1)*scale > maxWidth {
+		scale--
+	}
+	if fit := (maxWidth/scale + 1) / 6; len(runes) > fit {
+		runes = append(append([]rune{}, runes[:fit-3]...), '.', '.', '.')
+	}
+	return runes, scale
+}
+
+// drawTitle writes title in white on a dark band along the bottom, as large
+// as fits, shortened with "..." when even the smallest size is too wide.
+func drawTitle(img *image.RGBA, title string) {
+	if len(titleRunes(title)) == 0 {
+		return
+	}
+	size := img.Bounds().Dx()
+	runes, scale := fitTitle(titleRunes(title), size)
+
+	bandHeight := 7 * scale * 2
+	for y := size - bandHeight; y < size; y++ {
+		for x := 0; x < size; x++ {
+			offset := img.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				img.Pix[offset+c] = uint8(int(img.Pix[offset+c]) * 35 / 100)
+			}
+		}
+	}
+
+	textWidth := (6*len(runes) - 1) * scale
+	left := (size - textWidth) / 2
+	top := size - bandHeight + (bandHeight-7*scale)/2
+	white := image.NewUniform(color.White)
+	for i, r := range runes {
+		glyph := artworkFont[r]
+		for row := 0; row < 7; row++ {
+			for col := 0; col < 5; col++ {
+				if glyph[row]&(0x10>>col) == 0 {
+					continue
+				}
+				x := left + (6*i+col)*scale
+				y := top + row*scale
+				draw.Draw(img, image.Rect(x, y, x+scale, y+scale), white, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
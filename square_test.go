@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"strings"
+	"testing"
+)
+
+var (
+	testRed   = color.RGBA{255, 0, 0, 255}
+	testGreen = color.RGBA{0, 255, 0, 255}
+	testBlue  = color.RGBA{0, 0, 255, 255}
+)
+
+// stripes builds a w x h image in three bands along its long side: red,
+// blue over the centered square, green.
+func stripes(w int, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{testBlue}, image.Point{}, draw.Src)
+	if w > h {
+		band := (w - h) / 2
+		draw.Draw(img, image.Rect(0, 0, band, h), &image.Uniform{testRed}, image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(w-band, 0, w, h), &image.Uniform{testGreen}, image.Point{}, draw.Src)
+	} else {
+		band := (h - w) / 2
+		draw.Draw(img, image.Rect(0, 0, w, band), &image.Uniform{testRed}, image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(0, h-band, w, h), &image.Uniform{testGreen}, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func TestSquareArtworkOptions(t *testing.T) {
+	tests := []struct {
+		setting string
+		want    string
+	}{
+		{"", "off"},
+		{"off", "off"},
+		{"crop", "crop"},
+		{" Letterbox ", "letterbox"},
+		{"bogus", "off"},
+	}
+	for _, tt := range tests {
+		if mode, _, _ := squareArtworkOptions(FeedOptions{SquareArtwork: tt.setting}); mode != tt.want {
+			t.Errorf("SquareArtwork %q = %q, want %q", tt.setting, mode, tt.want)
+		}
+	}
+}
+
+func TestRenderSquare(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    *image.RGBA
+		mode   string
+		size   int
+		points map[image.Point]color.RGBA
+	}{
+		{"crop landscape keeps the center", stripes(400, 200), "crop", 50,
+			map[image.Point]color.RGBA{{0, 0}: testBlue, {49, 25}: testBlue, {25, 49}: testBlue}},
+		{"crop portrait keeps the center", stripes(200, 400), "crop", 50,
+			map[image.Point]color.RGBA{{25, 0}: testBlue, {25, 49}: testBlue}},
+		{"crop enlarges", stripes(40, 20), "crop", 60,
+			map[image.Point]color.RGBA{{0, 0}: testBlue, {59, 59}: testBlue}},
+		{"letterbox landscape", stripes(400, 200), "letterbox", 40,
+			map[image.Point]color.RGBA{{5, 20}: testRed, {20, 20}: testBlue, {35, 20}: testGreen, {20, 2}: {63, 63, 127, 255}, {20, 37}: {63, 63, 127, 255}}},
+		{"letterbox portrait", stripes(200, 400), "letterbox", 40,
+			map[image.Point]color.RGBA{{20, 5}: testRed, {20, 20}: testBlue, {20, 35}: testGreen, {2, 20}: {63, 63, 127, 255}}},
+	}
+	for _, tt := range tests {
+		square := renderSquare(tt.src, tt.size, tt.mode)
+		if square.Bounds() != image.Rect(0, 0, tt.size, tt.size) {
+			t.Errorf("%s: bounds %v", tt.name, square.Bounds())
+		}
+		for p, want := range tt.points {
+			if got := square.RGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", tt.name, p, got, want)
+			}
+		}
+	}
+}
+
+func TestScaleInto(t *testing.T) {
+	// left half white, right half black
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(src, image.Rect(0, 0, 2, 4), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(2, 0, 4, 4), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	white, black := color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255}
+
+	tests := []struct {
+		name   string
+		dst    image.Rectangle
+		to     image.Rectangle
+		from   image.Rectangle
+		points map[image.Point]color.RGBA
+	}{
+		{"shrink averages boxes", image.Rect(0, 0, 2, 2), image.Rect(0, 0, 2, 2), src.Bounds(),
+			map[image.Point]color.RGBA{{0, 0}: white, {1, 1}: black}},
+		{"shrink across the edge blends", image.Rect(0, 0, 1, 1), image.Rect(0, 0, 1, 1), image.Rect(1, 0, 3, 2),
+			map[image.Point]color.RGBA{{0, 0}: {128, 128, 128, 255}}},
+		{"enlarge keeps the halves", image.Rect(0, 0, 8, 8), image.Rect(0, 0, 8, 8), src.Bounds(),
+			map[image.Point]color.RGBA{{0, 0}: white, {2, 4}: white, {5, 4}: black, {7, 7}: black}},
+		{"into an offset rectangle", image.Rect(0, 0, 6, 6), image.Rect(2, 2, 4, 4), src.Bounds(),
+			map[image.Point]color.RGBA{{2, 2}: white, {3, 3}: black, {0, 0}: {}, {5, 5}: {}}},
+		{"empty target", image.Rect(0, 0, 2, 2), image.Rectangle{}, src.Bounds(),
+			map[image.Point]color.RGBA{{0, 0}: {}}},
+	}
+	for _, tt := range tests {
+		dst := image.NewRGBA(tt.dst)
+		scaleInto(dst, tt.to, src, tt.from)
+		for p, want := range tt.points {
+			if got := dst.RGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", tt.name, p, got, want)
+			}
+		}
+	}
+}
+
+func TestFitTitle(t *testing.T) {
+	tests := []struct {
+		name      string
+		title     string
+		size      int
+		wantTitle string
+		wantScale int
+	}{
+		{"short title at full scale", "My Podcast", 1400, "MY PODCAST", 14},
+		{"drops unknown characters", "Café ☕ Talk", 1400, "CAF TALK", 14},
+		{"shrinks to fit", strings.Repeat("A", 20), 1400, strings.Repeat("A", 20), 10},
+		{"smallest scale still fits", strings.Repeat("A", 30), 1400, strings.Repeat("A", 30), 7},
+		{"shortened at the smallest scale", strings.Repeat("A", 40), 1400, strings.Repeat("A", 27) + "...", 7},
+		{"larger artwork keeps the proportion", strings.Repeat("A", 30), 3000, strings.Repeat("A", 30), 15},
+	}
+	for _, tt := range tests {
+		runes, scale := fitTitle(titleRunes(tt.title), tt.size)
+		if string(runes) != tt.wantTitle || scale != tt.wantScale {
+			t.Errorf("%s: fitTitle = %q at %d, want %q at %d", tt.name, string(runes), scale, tt.wantTitle, tt.wantScale)
+		}
+		if width := (6*len(runes) - 1) * scale; width > tt.size*9/10 {
+			t.Errorf("%s: %d px wide, more than 90%% of %d", tt.name, width, tt.size)
+		}
+	}
+}
+
+func TestDrawTitleDarkensBand(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1400, 1400))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{200, 200, 200, 255}}, image.Point{}, draw.Src)
+	drawTitle(img, "Hi")
+	if got := img.RGBAAt(5, 1399); got != (color.RGBA{70, 70, 70, 255}) {
+		t.Errorf("band pixel = %v, want darkened", got)
+	}
+	if got := img.RGBAAt(5, 5); got != (color.RGBA{200, 200, 200, 255}) {
+		t.Errorf("pixel above the band = %v, want unchanged", got)
+	}
+	if got := img.RGBAAt(700, 1400-2*7*14-1); got != (color.RGBA{200, 200, 200, 255}) {
+		t.Errorf("pixel just above the band = %v, want unchanged", got)
+	}
+}
+
+func TestSquareArtworkOffKeepsWidth(t *testing.T) {
+	oldSettings := settingsXML
+	defer func() { settingsXML = oldSettings }()
+	settingsXML.MediaFolder = t.TempDir() + "/"
+	os.MkdirAll(artworkDir("UC1"), 0777)
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, stripes(320, 180), nil)
+	os.WriteFile(artworkDir("UC1")+"abc.jpg", encoded.Bytes(), 0644)
+
+	file, width, err := SquareArtwork("UC1", "abc.jpg", "", FeedOptions{})
+	if err != nil || file != "abc.jpg" || width != 320 {
+		t.Errorf("SquareArtwork = %q, %d, %v, want the cached file at 320 px", file, width, err)
+	}
+	if _, width, _ := SquareArtwork("UC1", "missing.jpg", "", FeedOptions{}); width != 0 {
+		t.Errorf("width of a missing file = %d, want unknown", width)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// =========================================================
+// ======================= Feed State ======================
+// =========================================================
+
+// FeedState is what we remember about a generated feed between runs, keyed
+// by ChannelID in <Config>feedstate.json.
+type FeedState struct {
+	ChannelRefreshed time.Time   `json:"channel_refreshed"`
+	Name             string      `json:"name"`
+	ChannelThumbnail string      `json:"channel_thumbnail"`
+	Token            string      `json:"token,omitempty"`
+	LastRun          time.Time   `json:"last_run"`
+	LastResult       string      `json:"last_result,omitempty"`
+	LastError        string      `json:"last_error,omitempty"`
+	Runs             []RunRecord `json:"runs,omitempty"`
+	WebSubTopic      string      `json:"websub_topic,omitempty"`
+	WebSubSecret     string      `json:"websub_secret,omitempty"`
+	WebSubExpires    time.Time   `json:"websub_expires"`
+	// Fetched is when each episode was first fetched completely
+	Fetched map[string]time.Time `json:"fetched,omitempty"`
+	// Fetching is how much of each episode has been fetched until then
+	Fetching map[string]FetchProgress `json:"fetching,omitempty"`
+}
+
+// RunRecord is one Run_YTDLP or NotifyYouTube run of a feed.
+type RunRecord struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// runHistoryLength is how many runs are kept per feed.
+const runHistoryLength = 20
+
+type StateFile struct {
+	Feeds map[string]*FeedState `json:"feeds"`
+}
+
+func stateFilePath() string {
+	return settingsXML.Config + "feedstate.json"
+}
+
+func LoadState() StateFile {
+	state := StateFile{Feeds: map[string]*FeedState{}}
+	content, err := os.ReadFile(stateFilePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Unable to read " + stateFilePath() + ": " + err.Error())
+		}
+		return state
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		log.Println("Unable to parse " + stateFilePath() + ": " + err.Error())
+	}
+	if state.Feeds == nil {
+		state.Feeds = map[string]*FeedState{}
+	}
+	return state
+}
+
+func SaveState(state StateFile) error {
+	content, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(stateFilePath(), content, 0600)
+}
+
+// UpdateFeedState loads the state file, applies update to one feed's state
+// and writes it back.
+func UpdateFeedState(pChannelID string, update func(feed *FeedState)) {
+	stateLock, err := LockFile(lockPath("feedstate"), true)
+	if err != nil {
+		log.Println("Unable to lock " + stateFilePath() + ": " + err.Error())
+	}
+	defer Unlock(stateLock)
+
+	state := LoadState()
+	feed, ok := state.Feeds[pChannelID]
+	if !ok {
+		feed = &FeedState{}
+		state.Feeds[pChannelID] = feed
+	}
+	update(feed)
+	if err := SaveState(state); err != nil {
+		log.Println("Unable to write " + stateFilePath() + ": " + err.Error())
+	}
+}
+
+// NotifyStateKey is the state key of a PodcastsNotifty entry, which has no
+// ChannelID of its own.
+func NotifyStateKey(pName string) string {
+	return "notify:" + pName
+}
+
+// RecordRun stores the outcome of a feed's run for the dashboard and API.
+func RecordRun(key string, started time.Time, runErr error) {
+	record := RunRecord{Started: started, Finished: time.Now(), Result: "ok"}
+	if runErr != nil {
+		record.Result = "failed"
+		record.Error = runErr.Error()
+		log.Println("RUN FAILED: " + key + ": " + runErr.Error())
+	}
+	RecordRunMetrics(key, runErr)
+	UpdateFeedState(key, func(feed *FeedState) {
+		feed.LastRun = record.Finished
+		feed.LastResult = record.Result
+		feed.LastError = record.Error
+		feed.Runs = append(feed.Runs, record)
+		if len(feed.Runs) > runHistoryLength {
+			feed.Runs = feed.Runs[len(feed.Runs)-runHistoryLength:]
+		}
+	})
+}
+
+func GetFeedState(pChannelID string) FeedState {
+	if feed, ok := LoadState().Feeds[pChannelID]; ok {
+		return *feed
+	}
+	return FeedState{}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// =========================================================
+// ================== RSS Channel / Item Templates =========
+// =========================================================
+
+// ChannelData is what channel templates (RSSTemplate) are executed with.
+type ChannelData struct {
+	Name        string
+	ChannelID   string
+	Link        string
+	Image       string
+	Description string
+	YouTubeURL  string
+	HTTPHost    string
+	FeedURL     string
+	Info        map[string]interface{}
+}
+
+// EpisodeData is what item templates (ItemTemplate) are executed with.
+type EpisodeData struct {
+	ID              string
+	GUID            string
+	Title           string
+	Description     string
+	Link            string
+	Thumbnail       string
+	ThumbnailWidth  int
+	Uploader        string
+	UploaderURL     string
+	ChannelURL      string
+	Duration        string
+	DurationSeconds int
+	Published       time.Time
+	PubDate         string
+	EnclosureURL    string
+	EnclosureType   string
+	EnclosureLength string
+	Transcripts     []Transcript
+	Channel         ChannelData
+	Info            map[string]interface{}
+}
+
+const defaultItemTemplate = `<item>
+			<title>{{cdata .Title}}</title>
+			<description>{{cdata .Description}}</description>
+			<link>{{xml .Link}}</link>
+			<guid isPermaLink="false">{{xml .GUID}}</guid>
+			<pubDate>{{.PubDate}}</pubDate>
+			<itunes:subtitle>{{cdata .UploaderURL}}</itunes:subtitle>
+			<itunes:summary>{{cdata .UploaderURL}}</itunes:summary>
+			<itunes:author>{{cdata .UploaderURL}}</itunes:author>
+			<author>{{cdata .UploaderURL}}</author>
+{{- if .Thumbnail}}
+			<itunes:image href="{{xml .Thumbnail}}"/>
+{{- end}}
+			<itunes:explicit>false</itunes:explicit>
+			<itunes:keywords>youtube</itunes:keywords>
+			<enclosure url="{{xml .EnclosureURL}}" type="{{.EnclosureType}}" length="{{xml .EnclosureLength}}"/>
+			<podcast:person href="{{xml .ChannelURL}}"{{if .Thumbnail}} img="{{xml .Thumbnail}}"{{end}}>{{xml .UploaderURL}}</podcast:person>
+{{- if and .Thumbnail .ThumbnailWidth}}
+			<podcast:images srcset="{{xml .Thumbnail}} {{.ThumbnailWidth}}w"/>
+{{- end}}
+			<itunes:duration>{{.Duration}}</itunes:duration>
+{{- range .Transcripts}}
+			<podcast:transcript url="{{xml .URL}}" type="{{.Type}}"{{if .Language}} language="{{.Language}}"{{end}}{{if .Rel}} rel="{{.Rel}}"{{end}}/>
+{{- end}}
+		</item>`
+
+// EnclosureType is the MIME type podcast apps expect for a media file.
+func EnclosureType(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".m4a":
+		return "audio/mp4"
+	case ".ogg", ".opus":
+		return "audio/ogg"
+	case ".webm":
+		return "video/webm"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".mov":
+		return "video/quicktime"
+	default:
+		return "video/mp4"
+	}
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func cdata(s string) string {
+	return "<![CDATA[" + strings.ReplaceAll(s, "]]>", "]]]]><![CDATA[>") + "]]>"
+}
+
+var templateFuncs = template.FuncMap{
+	"xml":   xmlEscape,
+	"cdata": cdata,
+	"rfc2822": func(t time.Time) string {
+		return t.Format(time.RFC1123Z)
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+	"truncate": func(n int, s string) string {
+		runes := []rune(s)
+		if len(runes) <= n {
+			return s
+		}
+		return string(runes[:n]) + "…"
+	},
+	"default": func(def string, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+	"info": func(info map[string]interface{}, key string) string {
+		if v, ok := info[key]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	},
+	"replace": strings.ReplaceAll,
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"trim":    strings.TrimSpace,
+}
+
+func executeTemplate(name string, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// RenderChannelTemplate builds a new feed from an RSSTemplate file. Go
+// template actions run first, then the original [PLACEHOLDER] substitutions,
+// so templates written for either syntax keep working.
+func RenderChannelTemplate(templatePath string, channel ChannelData) (string, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", err
+	}
+
+	rssTemplateData := string(content)
+	if strings.Contains(rssTemplateData, "{{") {
+		rssTemplateData, err = executeTemplate(templatePath, rssTemplateData, channel)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	rssTemplateData = strings.ReplaceAll(rssTemplateData, "[CHANNEL_LINK]", channel.Link)
+	rssTemplateData = strings.ReplaceAll(rssTemplateData, "[PODCAST_TITLE]", channel.Name)
+	rssTemplateData = strings.ReplaceAll(rssTemplateData, "[PODCAST_IMAGE]", channel.Image)
+	rssTemplateData = strings.ReplaceAll(rssTemplateData, "[PODCAST_DESCRIPTION]", channel.Description)
+	return rssTemplateData, nil
+}
+
+// RenderItemTemplate renders one <item> block, using the built-in markup when
+// no ItemTemplate is configured.
+func RenderItemTemplate(templatePath string, episode EpisodeData) (string, error) {
+	text := defaultItemTemplate
+	name := "item"
+	if templatePath != "" {
+		content, err := os.ReadFile(templatePath)
+		if err != nil {
+			return "", err
+		}
+		text = string(content)
+		name = templatePath
+	}
+	return executeTemplate(name, text, episode)
+}
+
+// ChannelTemplatePath and ItemTemplatePath pick the per-feed template, falling
+// back to the global settings.
+func ChannelTemplatePath(RSSTemplate string, pOptions FeedOptions) string {
+	if pOptions.RSSTemplate != "" {
+		return pOptions.RSSTemplate
+	}
+	return RSSTemplate
+}
+
+func ItemTemplatePath(pOptions FeedOptions) string {
+	if pOptions.ItemTemplate != "" {
+		return pOptions.ItemTemplate
+	}
+	return settingsXML.ItemTemplate
+}
+
+func ReadInfoJSON(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info map[string]interface{}
+	err = json.Unmarshal(content, &info)
+	return info, err
+}
+
+// GetChannelData reads <ChannelID>.info.json for the channel description and
+// avatar. pChannelThumbnail from the settings wins over the avatar; either is
+// cached in the feed's artwork folder.
+func GetChannelData(sMediaFolder string, pName string, pChannelID string, pChannelThumbnail string, pYouTubeURL string, channelLink string, pOptions FeedOptions) (ChannelData, error) {
+	channel := ChannelData{
+		Name:       pName,
+		ChannelID:  pChannelID,
+		Link:       channelLink,
+		YouTubeURL: pYouTubeURL,
+		HTTPHost:   settingsXML.HTTPHost,
+		FeedURL:    WithToken(FeedURL(RSSFileName(pChannelID)), FeedToken(pChannelID, pOptions)),
+		Info:       map[string]interface{}{},
+	}
+
+	channel_filename_json := sMediaFolder + pChannelID + "/" + pChannelID + ".info.json"
+	mapresult2, maperr2 := ReadInfoJSON(channel_filename_json)
+	if maperr2 != nil {
+		return channel, errors.New("Error reading JSON File " + channel_filename_json + ": " + maperr2.Error())
+	}
+	channel.Info = mapresult2
+
+	// ~~~~~~~~~~~ Get Description ~~~~~~~~~~~~~~
+	channel.Description = fmt.Sprint(mapresult2["description"])
+
+	if pChannelThumbnail == "" {
+		// ~~~~~~~~ Get Channel Thumbnail ~~~~~~~~~~~
+		thumbnails, _ := mapresult2["thumbnails"].([]interface{})
+		for i := len(thumbnails) - 1; i >= 0; i-- {
+			thumb, _ := thumbnails[i].(map[string]interface{})
+			thumbid, _ := thumb["id"].(string)
+			thumburl, _ := thumb["url"].(string)
+
+			if thumbid == "avatar_uncropped" {
+				channel.Image = thumburl
+				break
+			}
+		}
+	} else {
+		channel.Image = pChannelThumbnail
+	}
+
+	// ~~~~~~~~~ Cache Channel Artwork ~~~~~~~~~~
+	if channel.Image != "" {
+		cached, cacheErr := ChannelArtwork(pChannelID, pName, channel.Image, pOptions)
+		if cacheErr == nil {
+			channel.Image = cached
+		} else if pChannelThumbnail == "" {
+			log.Println("Channel avatar not cached: " + cacheErr.Error())
+			channel.Image = ""
+		} else {
+			log.Println("Channel thumbnail not cached, linking it: " + cacheErr.Error())
+		}
+	}
+
+	log.Println("Channel Thumbnail: " + channel.Image)
+	return channel, nil
+}
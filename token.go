@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// =========================================================
+// ================ Private Feed Access Tokens =============
+// =========================================================
+
+var tokenParamRegexp = regexp.MustCompile(`(\?|&amp;|&)token=[^"&#<\s]*`)
+
+func NewToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Unable to generate token: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// FeedToken returns the access token of a feed with Private set, creating one
+// on first use, or "" for a public feed.
+func FeedToken(pChannelID string, pOptions FeedOptions) string {
+	if !settingBool(pOptions.Private) {
+		return ""
+	}
+	token := GetFeedState(pChannelID).Token
+	if token == "" {
+		token = NewToken()
+		UpdateFeedState(pChannelID, func(feed *FeedState) {
+			feed.Token = token
+		})
+		log.Println("New access token for private feed: " + pChannelID)
+	}
+	return token
+}
+
+// WithToken sets the token query parameter of a URL, removing any previous
+// token. An empty token leaves the URL without one.
+func WithToken(rawURL string, token string) string {
+	escaped := strings.Contains(rawURL, "&amp;")
+	rawURL = tokenParamRegexp.ReplaceAllString(rawURL, "")
+	if !strings.Contains(rawURL, "?") {
+		if i := strings.Index(rawURL, "&amp;"); i >= 0 {
+			rawURL = rawURL[:i] + "?" + rawURL[i+len("&amp;"):]
+		} else if i := strings.Index(rawURL, "&"); i >= 0 {
+			rawURL = rawURL[:i] + "?" + rawURL[i+1:]
+		}
+	}
+	if token == "" {
+		return rawURL
+	}
+	if !strings.Contains(rawURL, "?") {
+		return rawURL + "?token=" + token
+	}
+	if escaped {
+		return rawURL + "&amp;token=" + token
+	}
+	return rawURL + "&token=" + token
+}
+
+// ApplyFeedToken rewrites every HTTPHost URL in the feed's url="" and href=""
+// attributes to carry token, so rotating or removing a token fixes items that
+// were written with the old one.
+func ApplyFeedToken(doc FeedDocument, token string) FeedDocument {
+	if settingsXML.HTTPHost == "" {
+		return doc
+	}
+	hostURLRegexp := regexp.MustCompile(`((?:url|href)=")(` + regexp.QuoteMeta(settingsXML.HTTPHost) + `[^"]*)"`)
+	retoken := func(text string) string {
+		return hostURLRegexp.ReplaceAllStringFunc(text, func(match string) string {
+			parts := hostURLRegexp.FindStringSubmatch(match)
+			return parts[1] + WithToken(parts[2], token) + "\""
+		})
+	}
+
+	doc.Head = retoken(doc.Head)
+	items := make([]FeedItem, len(doc.Items))
+	for i, item := range doc.Items {
+		item.XML = retoken(item.XML)
+		items[i] = item
+	}
+	doc.Items = items
+	return doc
+}
+
+// RotateFeedToken gives a private feed a new token and rewrites its feed
+// files, so the old feed and media URLs stop working.
+func RotateFeedToken(sRSSFolder string, pChannelID string, pOptions FeedOptions) error {
+	token := NewToken()
+	UpdateFeedState(pChannelID, func(feed *FeedState) {
+		feed.Token = token
+	})
+	log.Println("Token rotated: " + pChannelID)
+
+	if !IsValid(sRSSFolder + RSSFileName(pChannelID)) {
+		return nil
+	}
+	feedLock := LockFeed(pChannelID)
+	defer Unlock(feedLock)
+
+	doc, err := LoadFeedSet(sRSSFolder, pChannelID)
+	if err != nil {
+		return err
+	}
+	if err := WriteFeedSet(sRSSFolder, pChannelID, doc, pOptions); err != nil {
+		return err
+	}
+	log.Println("Private feed URL: " + WithToken(FeedURL(RSSFileName(pChannelID)), token))
+	return nil
+}
+
+// feedFileChannelID maps a file in RSSFolder back to the feed it belongs to.
+func feedFileChannelID(fileName string) (ConfiguredFeed, bool) {
+	for _, feed := range ConfiguredFeeds() {
+		id := feed.ChannelID
+		if fileName == RSSFileName(id) || fileName == AtomFileName(id) || fileName == JSONFeedFileName(id) ||
+			(strings.HasPrefix(fileName, id+"RSS-archive-") && strings.HasSuffix(fileName, ".xml")) {
+			return feed, true
+		}
+	}
+	return ConfiguredFeed{}, false
+}
+
+func mediaChannelID(rel string) (ConfiguredFeed, bool) {
+	first := strings.SplitN(strings.TrimPrefix(rel, "/"), "/", 2)[0]
+	for _, feed := range ConfiguredFeeds() {
+		if feed.ChannelID == first {
+			return feed, true
+		}
+	}
+	return ConfiguredFeed{}, false
+}
+
+// TokenAllowed checks the token query parameter of a request for a private
+// feed's files.
+func TokenAllowed(feed ConfiguredFeed, given string) bool {
+	if !settingBool(feed.Options.Private) {
+		return true
+	}
+	expected := GetFeedState(feed.ChannelID).Token
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(given)) == 1
+}
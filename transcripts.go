@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// =========================================================
+// ============ Transcripts from YouTube Subtitles =========
+// =========================================================
+
+// Transcript is one podcast:transcript entry of an item.
+type Transcript struct {
+	URL      string
+	Type     string
+	Language string
+	Rel      string
+}
+
+type subtitleCue struct {
+	Start string
+	End   string
+	Lines []string
+}
+
+var subtitleTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// autoCaptionTagRegexp matches the word timings only YouTube's automatic
+// captions have, e.g. <00:00:01.234><c> word</c>.
+var autoCaptionTagRegexp = regexp.MustCompile(`<\d{2}:\d{2}(:\d{2})?\.\d{3}>|<c>`)
+
+// SubtitleArgs are the extra yt-dlp arguments to fetch manual and automatic
+// subtitles as WebVTT for the feed's Subtitles languages.
+func SubtitleArgs(pOptions FeedOptions) []string {
+	langs := strings.ReplaceAll(strings.TrimSpace(pOptions.Subtitles), " ", "")
+	if langs == "" {
+		return nil
+	}
+	return []string{"--write-subs", "--write-auto-subs", "--sub-langs", langs, "--sub-format", "vtt/best", "--convert-subs", "vtt"}
+}
+
+// vttTimestamp normalises "00:01.000" and "00:00:01.000" to hh:mm:ss.mmm.
+func vttTimestamp(s string) string {
+	if strings.Count(s, ":") == 1 {
+		return "00:" + s
+	}
+	return s
+}
+
+// ParseVTT reads the cues of a WebVTT file and whether it holds automatic
+// captions.
+func ParseVTT(path string) ([]subtitleCue, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	var cues []subtitleCue
+	var cue *subtitleCue
+	auto := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.Contains(line, "-->") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				continue
+			}
+			cues = append(cues, subtitleCue{Start: vttTimestamp(fields[0]), End: vttTimestamp(fields[2])})
+			cue = &cues[len(cues)-1]
+			continue
+		}
+		if line == "" {
+			cue = nil
+			continue
+		}
+		if cue != nil {
+			if autoCaptionTagRegexp.MatchString(line) {
+				auto = true
+			}
+			text := strings.TrimSpace(subtitleTagRegexp.ReplaceAllString(line, ""))
+			if text != "" {
+				cue.Lines = append(cue.Lines, text)
+			}
+		}
+	}
+	return cues, auto, scanner.Err()
+}
+
+// cleanCues drops the lines YouTube's automatic captions repeat from the
+// previous cue, and the cues left empty by that. Only for automatic
+// captions: manual subtitles can repeat a line on purpose.
+func cleanCues(cues []subtitleCue) []subtitleCue {
+	var cleaned []subtitleCue
+	var previous []string
+	for _, cue := range cues {
+		var lines []string
+		for _, line := range cue.Lines {
+			if !containsString(previous, line) {
+				lines = append(lines, line)
+			}
+		}
+		if len(cue.Lines) > 0 {
+			previous = cue.Lines
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		cue.Lines = lines
+		cleaned = append(cleaned, cue)
+	}
+	return cleaned
+}
+
+func WriteSRT(path string, cues []subtitleCue) error {
+	var b strings.Builder
+	for i, cue := range cues {
+		b.WriteString(strconv.Itoa(i+1) + "\n")
+		b.WriteString(strings.Replace(cue.Start, ".", ",", 1) + " --> " + strings.Replace(cue.End, ".", ",", 1) + "\n")
+		b.WriteString(strings.Join(cue.Lines, "\n") + "\n\n")
+	}
+	return WriteFileAtomic(path, []byte(b.String()), 0666)
+}
+
+func WriteTranscriptText(path string, cues []subtitleCue) error {
+	var lines []string
+	for _, cue := range cues {
+		lines = append(lines, cue.Lines...)
+	}
+	return WriteFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0666)
+}
+
+// PrepareTranscripts converts every <id>.<lang>.vtt next to the media into
+// SRT and plain text and returns the transcripts to reference in the item.
+func PrepareTranscripts(fname_noext string, mediaURLBase string) []Transcript {
+	var transcripts []Transcript
+
+	vttFiles, _ := filepath.Glob(fname_noext + ".*.vtt")
+	for _, vttFile := range vttFiles {
+		lang := strings.TrimSuffix(strings.TrimPrefix(vttFile, fname_noext+"."), ".vtt")
+		srtFile := fname_noext + "." + lang + ".srt"
+		txtFile := fname_noext + "." + lang + ".txt"
+
+		if !IsValid(srtFile) || !IsValid(txtFile) {
+			cues, auto, err := ParseVTT(vttFile)
+			if err != nil {
+				log.Println("Unable to read subtitles " + vttFile + ": " + err.Error())
+				continue
+			}
+			if auto {
+				cues = cleanCues(cues)
+			}
+			if err := WriteSRT(srtFile, cues); err != nil {
+				log.Println("Unable to write " + srtFile + ": " + err.Error())
+				continue
+			}
+			if err := WriteTranscriptText(txtFile, cues); err != nil {
+				log.Println("Unable to write " + txtFile + ": " + err.Error())
+				continue
+			}
+			log.Println("Transcript converted: " + vttFile)
+		}
+
+		transcripts = append(transcripts,
+			Transcript{URL: mediaURLBase + filepath.Base(vttFile), Type: "text/vtt", Language: lang, Rel: "captions"},
+			Transcript{URL: mediaURLBase + filepath.Base(srtFile), Type: "application/x-subrip", Language: lang, Rel: "captions"},
+			Transcript{URL: mediaURLBase + filepath.Base(txtFile), Type: "text/plain", Language: lang},
+		)
+	}
+	return transcripts
+}
+
+// TranscriptFiles lists the subtitle and transcript files stored for a video.
+func TranscriptFiles(fname_noext string) []string {
+	var files []string
+	for _, ext := range []string{".vtt", ".srt", ".txt"} {
+		matches, _ := filepath.Glob(fname_noext + ".*" + ext)
+		files = append(files, matches...)
+	}
+	return files
+}
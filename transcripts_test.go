@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const autoCaptionsVTT = `WEBVTT
+Kind: captions
+Language: en
+
+00:00:00.000 --> 00:00:02.000 align:start position:0%
+hello<00:00:00.500><c> world</c>
+
+00:00:02.000 --> 00:00:02.010 align:start position:0%
+hello world
+ 
+
+00:00:02.010 --> 00:00:04.000 align:start position:0%
+hello world
+how<00:00:02.500><c> are</c><00:00:03.000><c> you</c>
+`
+
+const manualSubtitlesVTT = `WEBVTT
+
+1
+00:01.000 --> 00:02.000
+No.
+
+2
+00:02.000 --> 00:03.000
+No.
+
+3
+00:03.000 --> 00:04.500
+<i>Never.</i>
+`
+
+func writeVTT(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "video.en.vtt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseVTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		auto    bool
+		cues    []subtitleCue
+	}{
+		{
+			name:    "automatic captions",
+			content: autoCaptionsVTT,
+			auto:    true,
+			cues: []subtitleCue{
+				{Start: "00:00:00.000", End: "00:00:02.000", Lines: []string{"hello world"}},
+				{Start: "00:00:02.000", End: "00:00:02.010", Lines: []string{"hello world"}},
+				{Start: "00:00:02.010", End: "00:00:04.000", Lines: []string{"hello world", "how are you"}},
+			},
+		},
+		{
+			name:    "manual subtitles",
+			content: manualSubtitlesVTT,
+			cues: []subtitleCue{
+				{Start: "00:00:01.000", End: "00:00:02.000", Lines: []string{"No."}},
+				{Start: "00:00:02.000", End: "00:00:03.000", Lines: []string{"No."}},
+				{Start: "00:00:03.000", End: "00:00:04.500", Lines: []string{"Never."}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cues, auto, err := ParseVTT(writeVTT(t, test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if auto != test.auto {
+				t.Errorf("auto = %v, want %v", auto, test.auto)
+			}
+			if !reflect.DeepEqual(cues, test.cues) {
+				t.Errorf("cues = %#v, want %#v", cues, test.cues)
+			}
+		})
+	}
+}
+
+func TestCleanCues(t *testing.T) {
+	cues := []subtitleCue{
+		{Start: "00:00:00.000", End: "00:00:02.000", Lines: []string{"hello world"}},
+		{Start: "00:00:02.000", End: "00:00:02.010", Lines: []string{"hello world"}},
+		{Start: "00:00:02.010", End: "00:00:04.000", Lines: []string{"hello world", "how are you"}},
+	}
+	want := []subtitleCue{
+		{Start: "00:00:00.000", End: "00:00:02.000", Lines: []string{"hello world"}},
+		{Start: "00:00:02.010", End: "00:00:04.000", Lines: []string{"how are you"}},
+	}
+	if got := cleanCues(cues); !reflect.DeepEqual(got, want) {
+		t.Errorf("cleanCues = %#v, want %#v", got, want)
+	}
+}
+
+func TestPrepareTranscriptsKeepsManualRepeats(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "video.en.vtt"), []byte(manualSubtitlesVTT), 0644); err != nil {
+		t.Fatal(err)
+	}
+	PrepareTranscripts(filepath.Join(dir, "video"), "http://host/")
+	text, err := os.ReadFile(filepath.Join(dir, "video.en.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "No.\nNo.\nNever.\n" {
+		t.Errorf("transcript = %q", text)
+	}
+}
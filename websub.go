@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================
+// ================ WebSub Push Subscriptions ==============
+// =========================================================
+
+const defaultWebSubHub = "https://pubsubhubbub.appspot.com/subscribe"
+
+// webSubMaxAge skips pushes for old videos, which YouTube sends again when
+// their title or description is edited.
+const webSubMaxAge = 7 * 24 * time.Hour
+
+// WebSubTarget is a PodcastDownload or PodcastsNotifty entry that can be
+// subscribed to, keyed like its run state.
+type WebSubTarget struct {
+	Kind       string
+	Name       string
+	StateKey   string
+	YouTubeURL string
+	Run        func(videoURL string) error
+}
+
+type webSubEntry struct {
+	VideoID   string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	ChannelID string `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+	Title     string `xml:"http://www.w3.org/2005/Atom title"`
+	Published string `xml:"http://www.w3.org/2005/Atom published"`
+}
+
+type webSubFeed struct {
+	Entries []webSubEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+func webSubHub() string {
+	if settingsXML.WebSubHub != "" {
+		return settingsXML.WebSubHub
+	}
+	return defaultWebSubHub
+}
+
+func WebSubTopic(channelID string) string {
+	return "https://www.youtube.com/xml/feeds/videos.xml?channel_id=" + channelID
+}
+
+func webSubCallback(stateKey string) string {
+	return strings.TrimSuffix(settingsXML.HTTPHost, "/") + "/websub/" + url.PathEscape(stateKey)
+}
+
+// WebSubTargets lists the entries that get push subscriptions.
+func WebSubTargets() []WebSubTarget {
+	current := SettingsSnapshot()
+	var targets []WebSubTarget
+	for _, podcast := range current.PodcastDownload {
+		podcast := podcast
+		targets = append(targets, WebSubTarget{Kind: "PodcastDownload", Name: podcast.Name, StateKey: podcast.ChannelID, YouTubeURL: podcast.YouTubeURL,
+			Run: func(videoURL string) error { return RunPodcastDownload(podcast, videoURL) }})
+	}
+	for _, notify := range current.PodcastsNotifty {
+		notify := notify
+		targets = append(targets, WebSubTarget{Kind: "PodcastsNotifty", Name: notify.Name, StateKey: NotifyStateKey(notify.Name), YouTubeURL: notify.YouTubeURL,
+			Run: func(videoURL string) error { return RunPodcastsNotifty(notify, videoURL) }})
+	}
+	return targets
+}
+
+func findWebSubTarget(stateKey string) (WebSubTarget, bool) {
+	for _, target := range WebSubTargets() {
+		if target.StateKey == stateKey {
+			return target, true
+		}
+	}
+	return WebSubTarget{}, false
+}
+
+// serverLock is held by the serve process for as long as it runs.
+var serverLock *os.File
+
+// LockServer marks this process as the one receiving pushes.
+func LockServer() {
+	lock, err := LockFile(lockPath("server"), false)
+	if err != nil {
+		log.Println("Unable to lock " + lockPath("server") + ": " + err.Error())
+		return
+	}
+	serverLock = lock
+}
+
+// ServerRunning reports whether a serve process is up to receive pushes.
+func ServerRunning() bool {
+	lock, err := LockFile(lockPath("server"), false)
+	if err == errLocked {
+		return true
+	}
+	Unlock(lock)
+	return false
+}
+
+// WebSubActive reports whether a verified, unexpired subscription covers the
+// entry and the server is running to receive its pushes, so the cron run can
+// leave it to them.
+func WebSubActive(stateKey string) bool {
+	if !settingBool(settingsXML.WebSub) {
+		return false
+	}
+	return time.Now().Before(GetFeedState(stateKey).WebSubExpires) && ServerRunning()
+}
+
+// WebSubSubscribe asks the hub to (re)subscribe one entry. The hub confirms
+// asynchronously with a GET to the callback.
+func WebSubSubscribe(target WebSubTarget, mode string) error {
+	if strings.Contains(target.YouTubeURL, "list=") {
+		return errors.New("playlists have no WebSub topic: " + target.YouTubeURL)
+	}
+	channelID, err := ResolveChannelID(target.YouTubeURL)
+	if err != nil {
+		return err
+	}
+
+	topic := WebSubTopic(channelID)
+	secret := GetFeedState(target.StateKey).WebSubSecret
+	if secret == "" {
+		secret = NewToken()
+	}
+	UpdateFeedState(target.StateKey, func(feed *FeedState) {
+		feed.WebSubTopic = topic
+		feed.WebSubSecret = secret
+	})
+
+	form := url.Values{
+		"hub.callback":      {webSubCallback(target.StateKey)},
+		"hub.mode":          {mode},
+		"hub.topic":         {topic},
+		"hub.verify":        {"async"},
+		"hub.secret":        {secret},
+		"hub.lease_seconds": {strconv.Itoa(settingInt(settingsXML.WebSubLeaseSeconds, 432000))},
+	}
+	resp, err := http.PostForm(webSubHub(), form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New("hub answered " + resp.Status + ": " + strings.TrimSpace(string(body)))
+	}
+	log.Println("WebSub " + mode + " requested: " + target.Name + " (" + topic + ")")
+	return nil
+}
+
+// RenewWebSubSubscriptions subscribes every entry whose lease is missing or
+// ends within renewBefore.
+func RenewWebSubSubscriptions(renewBefore time.Duration) {
+	for _, target := range WebSubTargets() {
+		if time.Until(GetFeedState(target.StateKey).WebSubExpires) > renewBefore {
+			continue
+		}
+		if err := WebSubSubscribe(target, "subscribe"); err != nil {
+			log.Println("WebSub subscribe failed: " + target.Name + ": " + err.Error())
+		}
+	}
+}
+
+// RunWebSubRenewal keeps the leases renewed while the server runs.
+func RunWebSubRenewal() {
+	for {
+		RenewWebSubSubscriptions(24 * time.Hour)
+		time.Sleep(time.Hour)
+	}
+}
+
+// validWebSubSignature checks X-Hub-Signature ("sha1=<hex>", or sha256 /
+// sha512) against the subscription's secret.
+func validWebSubSignature(secret string, signature string, body []byte) bool {
+	parts := strings.SplitN(signature, "=", 2)
+	if secret == "" || len(parts) != 2 {
+		return false
+	}
+	var newHash func() hash.Hash
+	switch parts[0] {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+	given, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// webSubRun runs a pushed video, replaced in tests.
+var webSubRun = runPushedVideo
+
+// runPushedVideo runs the entry for one video, waiting for any cron or API
+// run to finish first so pushes are never dropped.
+func runPushedVideo(target WebSubTarget, videoID string) {
+	runLock, err := LockFile(lockPath("DownloadYouTubeGo"), true)
+	if err != nil {
+		log.Println("WebSub run lock failed: " + err.Error())
+		return
+	}
+	defer Unlock(runLock)
+
+	log.Println("WebSub run: " + target.Name + ": " + videoID)
+	if err := target.Run("https://www.youtube.com/watch?v=" + videoID); err != nil {
+		log.Println("WebSub run failed: " + target.Name + ": " + err.Error())
+	}
+}
+
+// webSubHandler answers hub verification GETs and content distribution POSTs
+// at <base>websub/<state key>.
+func webSubHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stateKey, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		feedState := GetFeedState(stateKey)
+		target, configured := findWebSubTarget(stateKey)
+
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			mode := query.Get("hub.mode")
+			if query.Get("hub.topic") != feedState.WebSubTopic || feedState.WebSubTopic == "" {
+				http.NotFound(w, r)
+				return
+			}
+			switch {
+			case mode == "subscribe" && configured:
+				lease := settingInt(query.Get("hub.lease_seconds"), 0)
+				UpdateFeedState(stateKey, func(feed *FeedState) {
+					feed.WebSubExpires = time.Now().Add(time.Duration(lease) * time.Second)
+				})
+				log.Println("WebSub subscription verified: " + target.Name + " (" + strconv.Itoa(lease) + "s)")
+			case mode == "unsubscribe":
+				UpdateFeedState(stateKey, func(feed *FeedState) {
+					feed.WebSubExpires = time.Time{}
+				})
+				log.Println("WebSub unsubscribe verified: " + stateKey)
+			case mode == "denied":
+				log.Println("WebSub subscription denied: " + stateKey + ": " + query.Get("hub.reason"))
+				w.WriteHeader(http.StatusOK)
+				return
+			default:
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, query.Get("hub.challenge"))
+
+		case http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// a wrong signature still gets a 2xx, as the spec asks, but is ignored
+			if !validWebSubSignature(feedState.WebSubSecret, r.Header.Get("X-Hub-Signature"), body) {
+				log.Println("WebSub push with invalid signature ignored: " + stateKey)
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			if !configured {
+				return
+			}
+
+			var feed webSubFeed
+			if err := xml.Unmarshal(body, &feed); err != nil {
+				log.Println("WebSub push not parsed: " + err.Error())
+				return
+			}
+			for _, entry := range feed.Entries {
+				if entry.VideoID == "" || feedState.WebSubTopic != WebSubTopic(entry.ChannelID) {
+					continue
+				}
+				if published, err := time.Parse(time.RFC3339, entry.Published); err == nil && time.Since(published) > webSubMaxAge {
+					log.Println("WebSub push for old video ignored: " + entry.VideoID)
+					continue
+				}
+				log.Println("WebSub push: " + target.Name + ": " + entry.Title + " (" + entry.VideoID + ")")
+				go webSubRun(target, entry.VideoID)
+			}
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
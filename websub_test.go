@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSubSubscribeVerifyPush(t *testing.T) {
+	oldSettings, oldRun := settingsXML, webSubRun
+	defer func() { settingsXML, webSubRun = oldSettings, oldRun }()
+
+	pushed := make(chan string, 1)
+	webSubRun = func(target WebSubTarget, videoID string) { pushed <- target.Name + " " + videoID }
+
+	callback := httptest.NewServer(webSubHandler("/websub/"))
+	defer callback.Close()
+
+	// the hub verifies the subscription with a GET to the callback, then
+	// pushes a signed feed
+	verified := make(chan url.Values, 1)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form := r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			query := url.Values{
+				"hub.mode":          {"subscribe"},
+				"hub.topic":         {form.Get("hub.topic")},
+				"hub.challenge":     {"challenge-123"},
+				"hub.lease_seconds": {"3600"},
+			}
+			resp, err := http.Get(form.Get("hub.callback") + "?" + query.Encode())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "challenge-123" {
+				t.Errorf("verify answered %q, want the challenge", body)
+			}
+			verified <- form
+		}()
+	}))
+	defer hub.Close()
+
+	settingsXML = settings{
+		Config:    t.TempDir() + "/",
+		HTTPHost:  callback.URL,
+		WebSub:    "true",
+		WebSubHub: hub.URL,
+		PodcastsNotifty: []PodcastsNotifty{
+			{Name: "Uploads", YouTubeURL: "https://www.youtube.com/channel/UC0123456789abcdefghijkl"},
+		},
+	}
+	target := WebSubTargets()[0]
+	if err := WebSubSubscribe(target, "subscribe"); err != nil {
+		t.Fatal(err)
+	}
+
+	var form url.Values
+	select {
+	case form = <-verified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not verified")
+	}
+	if form.Get("hub.topic") != WebSubTopic("UC0123456789abcdefghijkl") || form.Get("hub.secret") == "" {
+		t.Fatalf("subscribe request = %v", form)
+	}
+	if expires := GetFeedState(target.StateKey).WebSubExpires; time.Until(expires) < 59*time.Minute {
+		t.Errorf("lease ends %v, want in an hour", expires)
+	}
+	if WebSubActive(target.StateKey) {
+		t.Error("WebSubActive without a running server")
+	}
+	LockServer()
+	if !WebSubActive(target.StateKey) {
+		t.Error("WebSubActive false while the server runs")
+	}
+	Unlock(serverLock)
+
+	feed := `<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">
+<entry><yt:videoId>abcdefghijk</yt:videoId><yt:channelId>UC0123456789abcdefghijkl</yt:channelId>
+<title>New video</title><published>` + time.Now().Format(time.RFC3339) + `</published></entry></feed>`
+	push := func(secret string) {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write([]byte(feed))
+		req, _ := http.NewRequest(http.MethodPost, webSubCallback(target.StateKey), strings.NewReader(feed))
+		req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Errorf("push answered %v", resp.Status)
+		}
+	}
+
+	push("wrong secret")
+	select {
+	case run := <-pushed:
+		t.Fatalf("push with a wrong signature ran %q", run)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	push(form.Get("hub.secret"))
+	select {
+	case run := <-pushed:
+		if run != "Uploads abcdefghijk" {
+			t.Errorf("push ran %q", run)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signed push did not run")
+	}
+}